@@ -32,7 +32,7 @@ func benchmarkAccuracy(groundTruthFilename, queryResultFilename, outputFilename
 	out.Write([]string{"Query", "Precision", "Recall", "F1"})
 	for i := range queryResults {
 		line := []string{
-			queryResults[i].queryKey.(string),
+			queryResults[i].queryKey,
 			strconv.FormatFloat(precisions[i], 'f', -1, 64),
 			strconv.FormatFloat(recalls[i], 'f', -1, 64),
 			strconv.FormatFloat(f1s[i], 'f', -1, 64),
@@ -52,11 +52,11 @@ func recallPrecision(result, groundTruth queryResult) (recall, precision float64
 	if len(result.candidates) == 0 {
 		return 0.0, 0.0
 	}
-	truth := make(map[interface{}]bool)
+	truth := make(map[string]bool)
 	for _, v := range groundTruth.candidates {
 		truth[v] = true
 	}
-	test := make(map[interface{}]bool)
+	test := make(map[string]bool)
 	for _, v := range result.candidates {
 		test[v] = true
 	}
@@ -88,7 +88,7 @@ func readQueryResultFile(queryResultFile string) []queryResult {
 	for scanner.Scan() {
 		raw := strings.Split(scanner.Text(), "\t")
 		key := raw[0]
-		candidates := make([]interface{}, len(raw[2:]))
+		candidates := make([]string, len(raw[2:]))
 		for i := range candidates {
 			candidates[i] = raw[2+i]
 		}
@@ -0,0 +1,188 @@
+package lshensemble
+
+import (
+	"math"
+	"sync"
+)
+
+// BandedLsh represents the classic MinHash LSH, which splits signatures
+// into L bands of K hash values each. Unlike LshForest, K and L are fixed
+// when the index is created, but every band is a hash map keyed by the
+// hash key of the band, so looking up a bucket takes constant time instead
+// of a binary search.
+type BandedLsh[K comparable] struct {
+	k             int
+	l             int
+	hashKeyFunc   hashKeyFunc
+	hashValueBits int
+	mu            sync.RWMutex
+	// bands maps the hash key of every band to the keys in the bucket.
+	bands []map[string][]K
+	// hashKeys are the hash keys of the indexed keys, concatenated in the
+	// order of the bands, which locate the buckets of a removed key.
+	hashKeys map[K][]byte
+	// pending are the keys added since the last call to Index().
+	pending []pendingEntry[K]
+}
+
+// NewBandedLsh initializes a banded MinHash LSH using 32 bit hash values,
+// with k hash functions per band and l bands.
+// initSize is the initial number of buckets of every band to allocate.
+func NewBandedLsh[K comparable](k, l, initSize int) *BandedLsh[K] {
+	return newBandedLsh[K](k, l, 32, initSize)
+}
+
+func newBandedLsh[K comparable](k, l, hashValueBits, initSize int) *BandedLsh[K] {
+	if k < 1 || l < 1 {
+		panic("k and l must be positive")
+	}
+	bands := make([]map[string][]K, l)
+	for i := range bands {
+		bands[i] = make(map[string][]K, initSize)
+	}
+	return &BandedLsh[K]{
+		k:             k,
+		l:             l,
+		hashKeyFunc:   hashKeyFuncFor(hashValueBits),
+		hashValueBits: hashValueBits,
+		bands:         bands,
+		hashKeys:      make(map[K][]byte, initSize),
+	}
+}
+
+// Add a key with MinHash signature into the index.
+// The key won't be searchable until Index() is called.
+func (b *BandedLsh[K]) Add(key K, sig []uint64) {
+	var hs []byte
+	for i := 0; i < b.l; i++ {
+		hs = b.hashKeyFunc(hs, sig[i*b.k:(i+1)*b.k])
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.pending = append(b.pending, pendingEntry[K]{key, hs})
+}
+
+// Remove a key from the index. The key stops appearing in query results
+// immediately.
+func (b *BandedLsh[K]) Remove(key K) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	pending := b.pending[:0]
+	for _, p := range b.pending {
+		if p.key != key {
+			pending = append(pending, p)
+		}
+	}
+	b.pending = pending
+	b.remove(key)
+}
+
+// remove deletes the indexed key from its buckets, the caller must hold
+// the lock.
+func (b *BandedLsh[K]) remove(key K) {
+	hs, exists := b.hashKeys[key]
+	if !exists {
+		return
+	}
+	width := len(hs) / b.l
+	for i, band := range b.bands {
+		hk := string(hs[i*width : (i+1)*width])
+		bucket := band[hk]
+		for j := range bucket {
+			if bucket[j] == key {
+				bucket[j] = bucket[len(bucket)-1]
+				bucket = bucket[:len(bucket)-1]
+				break
+			}
+		}
+		if len(bucket) == 0 {
+			delete(band, hk)
+		} else {
+			band[hk] = bucket
+		}
+	}
+	delete(b.hashKeys, key)
+}
+
+// Update replaces the MinHash signature of a key.
+// The new signature won't be searchable until Index() is called, and the key
+// does not appear in query results until then.
+func (b *BandedLsh[K]) Update(key K, sig []uint64) {
+	b.Remove(key)
+	b.Add(key, sig)
+}
+
+// Index makes all the keys added searchable. Adding a key that is already
+// indexed replaces its signature. The buckets are updated in place, so
+// queries wait until indexing finishes.
+func (b *BandedLsh[K]) Index() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, p := range b.pending {
+		b.remove(p.key)
+		width := len(p.hashKeys) / b.l
+		for i, band := range b.bands {
+			hk := string(p.hashKeys[i*width : (i+1)*width])
+			band[hk] = append(band[hk], p.key)
+		}
+		b.hashKeys[p.key] = p.hashKeys
+	}
+	b.pending = nil
+}
+
+// Query returns candidate keys given the query signature and parameters.
+// The number of hash functions per band is fixed, so k is ignored, and
+// only the first l bands are searched.
+func (b *BandedLsh[K]) Query(sig []uint64, k, l int, out chan<- K, done <-chan struct{}) {
+	if l == -1 || l > b.l {
+		l = b.l
+	}
+	// Collect the candidates first, so the lock is not held while
+	// waiting for the consumer.
+	var candidates []K
+	seens := make(map[K]bool)
+	var hk []byte
+	b.mu.RLock()
+	for i := 0; i < l; i++ {
+		hk = b.hashKeyFunc(hk[:0], sig[i*b.k:(i+1)*b.k])
+		for _, key := range b.bands[i][string(hk)] {
+			if !seens[key] {
+				seens[key] = true
+				candidates = append(candidates, key)
+			}
+		}
+	}
+	b.mu.RUnlock()
+	for _, key := range candidates {
+		select {
+		case out <- key:
+		case <-done:
+			return
+		}
+	}
+}
+
+// NumKeys returns the number of searchable keys.
+func (b *BandedLsh[K]) NumKeys() int {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return len(b.hashKeys)
+}
+
+// OptimalKL returns the fixed K, and the number of bands L no more than
+// the fixed L minimizing the false positive and negative probabilities for
+// containment search, as well as the probabilities.
+// where x is the indexed domain size, q is the query domain size,
+// and t is the containment threshold.
+func (b *BandedLsh[K]) OptimalKL(x, q int, t float64) (optK, optL int, fp, fn float64) {
+	minError := math.MaxFloat64
+	for l := 1; l <= b.l; l++ {
+		currFp := probFalsePositive(x, q, l, b.k, b.hashValueBits, t, integrationPrecision)
+		currFn := probFalseNegative(x, q, l, b.k, b.hashValueBits, t, integrationPrecision)
+		if currFp+currFn < minError {
+			minError = currFp + currFn
+			optL, fp, fn = l, currFp, currFn
+		}
+	}
+	return b.k, optL, fp, fn
+}
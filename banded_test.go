@@ -0,0 +1,86 @@
+package lshensemble
+
+import (
+	"context"
+	"io"
+	"testing"
+)
+
+func Test_BandedLsh(t *testing.T) {
+	f := NewBandedLsh[string](2, 4, 10)
+	sig1 := randomSignature(8, 1)
+	sig2 := randomSignature(8, 2)
+	f.Add("sig1", sig1)
+	f.Add("sig2", sig2)
+	if queryLshForest[string](f, sig1, 2, 4)["sig1"] {
+		t.Fatal("Key was returned before Index()")
+	}
+	f.Index()
+	if f.NumKeys() != 2 {
+		t.Fatalf("Number of keys %d, expecting 2", f.NumKeys())
+	}
+	found := queryLshForest[string](f, sig1, 2, 4)
+	if !found["sig1"] || found["sig2"] {
+		t.Fatalf("Query returned %v, expecting sig1", found)
+	}
+	// A query matching only the last band.
+	sig3 := randomSignature(8, 3)
+	copy(sig3[6:], sig2[6:])
+	if !queryLshForest[string](f, sig3, 2, 4)["sig2"] {
+		t.Fatal("Unable to retrieve key matching one band")
+	}
+	if queryLshForest[string](f, sig3, 2, 3)["sig2"] {
+		t.Fatal("Key matching an unsearched band was returned")
+	}
+
+	f.Remove("sig1")
+	if queryLshForest[string](f, sig1, 2, 4)["sig1"] {
+		t.Fatal("Removed key was returned")
+	}
+	f.Update("sig2", sig1)
+	f.Index()
+	if f.NumKeys() != 1 {
+		t.Fatalf("Number of keys %d, expecting 1", f.NumKeys())
+	}
+	if !queryLshForest[string](f, sig1, 2, 4)["sig2"] {
+		t.Fatal("Unable to retrieve updated key with new signature")
+	}
+	if queryLshForest[string](f, sig2, 2, 4)["sig2"] {
+		t.Fatal("Updated key was returned for its old signature")
+	}
+
+	k, l, _, _ := f.OptimalKL(100, 100, 0.5)
+	if k != 2 || l < 1 || l > 4 {
+		t.Errorf("OptimalKL returned k = %d, l = %d outside the fixed parameters", k, l)
+	}
+}
+
+func Test_LshEnsembleBanded(t *testing.T) {
+	parts := []Partition{{Lower: 1, Upper: 10}, {Lower: 11, Upper: 100}}
+	index := NewLshEnsembleBanded[int](parts, 128, 4, 0.5, 10)
+	sigs := make([][]uint64, 20)
+	for i := range sigs {
+		sigs[i] = randomSignature(128, int64(i))
+		if err := index.Add(i, sigs[i], i%2); err != nil {
+			t.Fatal(err)
+		}
+	}
+	index.Index()
+	for i := range sigs {
+		size := parts[i%2].Upper
+		results, err := index.QueryContext(context.Background(), sigs[i], size, 0.8)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var found bool
+		for _, key := range results {
+			found = found || key == i
+		}
+		if !found {
+			t.Fatalf("Unable to retrieve key %d", i)
+		}
+	}
+	if _, err := index.WriteTo(io.Discard); err == nil {
+		t.Error("Saving an index using BandedLsh should fail")
+	}
+}
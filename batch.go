@@ -0,0 +1,133 @@
+package lshensemble
+
+import (
+	"fmt"
+	"sync"
+)
+
+// Query is a query domain for QueryBatch.
+type Query[K comparable] struct {
+	// Key identifies the query in the results of QueryBatch,
+	// it must be unique within a batch.
+	Key K
+	// The MinHash signature of the query domain.
+	Signature []uint64
+	// The query domain size.
+	Size int
+	// The containment threshold.
+	Threshold float64
+}
+
+// hashKeyCache caches the hash keys of a query signature, so they are
+// generated once for all the LshForests sharing the same configuration.
+type hashKeyCache[K comparable] map[[3]int][][]byte
+
+func (c hashKeyCache[K]) get(f *LshForest[K], sig []uint64) [][]byte {
+	config := [3]int{f.k, f.l, f.hashValueBits}
+	hs, exists := c[config]
+	if !exists {
+		hs = f.hashKeys(sig, f.k)
+		c[config] = hs
+	}
+	return hs
+}
+
+// QueryBatch runs many queries using a pool of numWorkers goroutines,
+// and returns the candidate domain keys of every query by its key.
+// Each query is run by a single goroutine, searching the partitions one
+// after another, and the hash keys of the query signature are generated once
+// and shared by the partitions.
+// If dedup is true, queries with identical signature, size and threshold are
+// run only once, and share the same result slice.
+// All queries are validated before any query is run, and an error is
+// returned for the first query whose key is used by an earlier query, or
+// whose signature does not match the index.
+func (e *LshEnsemble[K]) QueryBatch(queries []Query[K], numWorkers int, dedup bool) (map[K][]K, error) {
+	keys := make(map[K]bool, len(queries))
+	for _, q := range queries {
+		if keys[q.Key] {
+			return nil, fmt.Errorf("Query %v: duplicate query key", q.Key)
+		}
+		keys[q.Key] = true
+		if err := e.checkSignature(q.Signature); err != nil {
+			return nil, fmt.Errorf("Query %v: %w", q.Key, err)
+		}
+	}
+	if numWorkers < 1 {
+		numWorkers = 1
+	}
+	// Map each query to the query actually run, which is the first query
+	// in the batch with the same signature, size and threshold.
+	runs := make([]int, len(queries))
+	seen := make(map[string]int)
+	hashKeyFunc := hashKeyFuncGen(HashValueSize)
+	for i, q := range queries {
+		runs[i] = i
+		if !dedup {
+			continue
+		}
+		id := fmt.Sprintf("%d %v %s", q.Size, q.Threshold, hashKeyFunc(nil, q.Signature))
+		if j, exists := seen[id]; exists {
+			runs[i] = j
+			continue
+		}
+		seen[id] = i
+	}
+	results := make([][]K, len(queries))
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	wg.Add(numWorkers)
+	for w := 0; w < numWorkers; w++ {
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				q := queries[i]
+				results[i] = e.querySequential(q.Signature, q.Size, q.Threshold)
+			}
+		}()
+	}
+	for i := range queries {
+		if runs[i] == i {
+			jobs <- i
+		}
+	}
+	close(jobs)
+	wg.Wait()
+	out := make(map[K][]K, len(queries))
+	for i, q := range queries {
+		out[q.Key] = results[runs[i]]
+	}
+	return out, nil
+}
+
+// querySequential searches the partitions one after another using a single
+// goroutine, and returns the candidate domain keys.
+func (e *LshEnsemble[K]) querySequential(sig []uint64, size int, threshold float64) []K {
+	parts, lshes := e.layout()
+	params := e.computeParams(parts, lshes, size, threshold)
+	result := make([]K, 0)
+	keyChan := make(chan K)
+	go func() {
+		cache := make(hashKeyCache[K])
+		for i, lsh := range lshes {
+			p := params[i]
+			if p.pruned {
+				continue
+			}
+			switch lsh := lsh.(type) {
+			case *LshForest[K]:
+				lsh.queryHashKeys(cache.get(lsh, sig), p.k, p.l, keyChan, nil)
+			case *LshForestArray[K]:
+				f := lsh.array[p.k-1]
+				f.queryHashKeys(cache.get(f, sig), p.k, p.l, keyChan, nil)
+			default:
+				lsh.Query(sig, p.k, p.l, keyChan, nil)
+			}
+		}
+		close(keyChan)
+	}()
+	for key := range keyChan {
+		result = append(result, key)
+	}
+	return result
+}
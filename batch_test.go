@@ -0,0 +1,69 @@
+package lshensemble
+
+import "testing"
+
+func Test_LshEnsembleQueryBatch(t *testing.T) {
+	recs := testDomainRecords(100, 64)
+	for _, index := range testIndexes(t, recs) {
+		queries := make([]Query[string], 0, len(recs)+1)
+		for _, rec := range recs {
+			queries = append(queries, Query[string]{rec.Key, rec.Signature, rec.Size, 0.8})
+		}
+		// A duplicate of the first query.
+		queries = append(queries, Query[string]{"duplicate", recs[0].Signature, recs[0].Size, 0.8})
+		results, err := index.QueryBatch(queries, 4, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(results) != len(queries) {
+			t.Fatalf("Expected %d results, got %d", len(queries), len(results))
+		}
+		for _, rec := range recs {
+			expected, _, err := index.QueryTimed(rec.Signature, rec.Size, 0.8)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(results[rec.Key]) != len(expected) {
+				t.Fatalf("Expected %d candidates, got %d", len(expected), len(results[rec.Key]))
+			}
+		}
+		// The duplicate query is not run again, and shares the result.
+		duplicate, first := results["duplicate"], results[recs[0].Key]
+		if len(first) == 0 || len(duplicate) != len(first) || &duplicate[0] != &first[0] {
+			t.Fatal("Duplicate query was run again")
+		}
+		if results, err = index.QueryBatch(queries, 4, false); err != nil {
+			t.Fatal(err)
+		}
+		duplicate, first = results["duplicate"], results[recs[0].Key]
+		if len(duplicate) != len(first) || &duplicate[0] == &first[0] {
+			t.Fatal("Duplicate query was not run without de-duplication")
+		}
+		// Queries sharing a key would overwrite each other's results.
+		last := recs[len(recs)-1]
+		queries = append(queries, Query[string]{"duplicate", last.Signature, last.Size, 0.8})
+		if _, err := index.QueryBatch(queries, 4, true); err == nil {
+			t.Fatal("Expected error for duplicate query keys")
+		}
+	}
+}
+
+func Test_HashKeyCache(t *testing.T) {
+	sig := randomSignature(16, 1)
+	cache := make(hashKeyCache[int])
+	f, g := NewLshForest[int](4, 4, 0), NewLshForest[int](4, 4, 0)
+	hs := cache.get(f, sig)
+	if gs := cache.get(g, sig); &gs[0][0] != &hs[0][0] {
+		t.Fatal("Hash keys are not shared by forests of the same configuration")
+	}
+	if len(cache) != 1 {
+		t.Fatalf("Cache has %d entries, expecting 1", len(cache))
+	}
+	h := NewLshForest[int](2, 8, 0)
+	if hs := cache.get(h, sig); len(hs) != 8 {
+		t.Fatalf("Got hash keys of %d bands, expecting 8", len(hs))
+	}
+	if len(cache) != 2 {
+		t.Fatalf("Cache has %d entries, expecting 2", len(cache))
+	}
+}
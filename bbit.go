@@ -0,0 +1,63 @@
+package lshensemble
+
+import "math"
+
+// BBit makes the index use b-bit MinHash, where only the lowest b bits
+// of every hash value are indexed, b is between 1 and 8.
+// This reduces the memory usage of the hash tables at the cost of more
+// false positives, which the LSH parameters are optimized for.
+// If the index also keeps domains, their signatures are stored packed.
+func BBit(b int) Option {
+	if b < 1 || b > 8 {
+		panic("b must be between 1 and 8")
+	}
+	return func(o *options) {
+		o.hashValueBits = b
+	}
+}
+
+// PackSignature packs the lowest b bits of every hash value in the
+// signature, b is between 1 and 8.
+func PackSignature(sig []uint64, b int) []byte {
+	return packedHashKeyFuncGen(b)(nil, sig)
+}
+
+// UnpackSignature reverses PackSignature, numHash is the number of hash
+// values in the packed signature.
+func UnpackSignature(data []byte, b, numHash int) []uint64 {
+	sig := make([]uint64, numHash)
+	var pos int
+	for i := range sig {
+		for bit := 0; bit < b; bit++ {
+			sig[i] = sig[i]<<1 | uint64(data[pos/8]>>uint(7-pos%8)&1)
+			pos++
+		}
+	}
+	return sig
+}
+
+// ContainmentBBit is similar to Containment, but only compares the lowest
+// b bits of the hash values, and corrects the estimated Jaccard similarity
+// for the accidental collisions of b-bit hash values.
+func ContainmentBBit(q, x []uint64, qSize, xSize, b int) float64 {
+	if qSize == 0 || xSize == 0 {
+		return 0.0
+	}
+	mask := uint64(1)<<uint(b) - 1
+	var eq int
+	for i, hv := range q {
+		if x[i]&mask == hv&mask {
+			eq++
+		}
+	}
+	c := math.Pow(2.0, -float64(b))
+	jaccard := (float64(eq)/float64(len(q)) - c) / (1.0 - c)
+	if jaccard < 0.0 {
+		return 0.0
+	}
+	containment := (float64(xSize)/float64(qSize) + 1.0) * jaccard / (1.0 + jaccard)
+	if containment > 1.0 {
+		return 1.0
+	}
+	return containment
+}
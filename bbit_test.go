@@ -0,0 +1,106 @@
+package lshensemble
+
+import (
+	"bytes"
+	"testing"
+)
+
+func Test_PackSignature(t *testing.T) {
+	sig := randomSignature(13, 1)
+	for b := 1; b <= 8; b++ {
+		packed := PackSignature(sig, b)
+		if len(packed) != hashKeyWidth(len(sig), b) {
+			t.Fatalf("b = %d: packed %d bytes", b, len(packed))
+		}
+		unpacked := UnpackSignature(packed, b, len(sig))
+		mask := uint64(1)<<uint(b) - 1
+		for i := range sig {
+			if unpacked[i] != sig[i]&mask {
+				t.Fatalf("b = %d: hash value %d is %d, expecting %d",
+					b, i, unpacked[i], sig[i]&mask)
+			}
+		}
+	}
+}
+
+func Test_ComparePrefix(t *testing.T) {
+	f := packedHashKeyFuncGen(3)
+	a := f(nil, []uint64{1, 2, 3})
+	b := f(nil, []uint64{1, 2, 4})
+	if comparePrefix(a, b, 6) != 0 {
+		t.Error("Prefixes of 2 hash values should be equal")
+	}
+	if comparePrefix(a, b, 9) != -1 || comparePrefix(b, a, 9) != 1 {
+		t.Error("Hash keys should be ordered by the last hash value")
+	}
+}
+
+func Test_LshForestBBit(t *testing.T) {
+	f := NewLshForestBBit[string](2, 4, 8, 3)
+	sig1 := randomSignature(32, 1)
+	sig2 := randomSignature(32, 2)
+	f.Add("sig1", sig1)
+	f.Add("sig2", sig2)
+	f.Index()
+	for i := range f.hashTables {
+		if len(f.hashTables[i].hashKey(0)) != 1 {
+			t.Fatalf("Hash key of 4 2-bit hash values should take 1 byte")
+		}
+	}
+	if !queryLshForest(f, sig1, 4, 8)["sig1"] {
+		t.Error("Query should find the identical signature")
+	}
+}
+
+func Test_LshEnsembleBBit(t *testing.T) {
+	recs := testDomainRecords(100, 64)
+	index, err := BootstrapLshEnsembleEquiDepth(4, 64, 4, len(recs), Recs2Chan(recs),
+		BBit(4), KeepDomains())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, rec := range recs {
+		result, err := index.QueryTopK(rec.Signature, rec.Size, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(result) == 0 || result[0].Score < 1.0 {
+			t.Fatalf("Query %s should have a containment score of 1: %v",
+				rec.Key, result)
+		}
+	}
+	var buf bytes.Buffer
+	if _, err := index.WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := ReadLshEnsemble[string](&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, rec := range recs {
+		found := false
+		candidates, _, err := loaded.QueryTimed(rec.Signature, rec.Size, 1.0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, key := range candidates {
+			if key == rec.Key {
+				found = true
+			}
+		}
+		if !found {
+			t.Fatalf("Query %s should find itself after reading", rec.Key)
+		}
+	}
+}
+
+func Test_OptimalKLBBit(t *testing.T) {
+	// Accidental collisions of 1-bit hash values make false positives
+	// more likely than with full hash values.
+	_, _, fp32, _ := optimalKL(4, 16, 64, 32, 100, 100, 0.5)
+	_, _, fp1, _ := optimalKL(4, 16, 64, 1, 100, 100, 0.5)
+	if fp1 <= fp32 {
+		t.Errorf("False positive with 1-bit hash values %f should be greater than %f",
+			fp1, fp32)
+	}
+}
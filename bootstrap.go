@@ -6,19 +6,22 @@ var (
 	errDomainSizeOrder = errors.New("Domain records must be sorted in ascending order of size")
 )
 
-func bootstrapOptimalPartitions(domains <-chan *DomainRecord, numPart int) ([]Partition, int) {
+func bootstrapOptimalPartitions[K comparable](domains <-chan *DomainRecord[K], numPart int) ([]Partition, int) {
 	sizes, counts := computeSizeDistribution(domains)
 	partitions := optimalPartitions(sizes, counts, numPart)
 	return partitions, len(sizes)
 }
 
-func bootstrapOptimal(index *LshEnsemble, sortedDomains <-chan *DomainRecord) error {
+func bootstrapOptimal[K comparable](index *LshEnsemble[K], sortedDomains <-chan *DomainRecord[K]) error {
 	var currPart int
 	var currSize int
 	for rec := range sortedDomains {
 		if currSize > rec.Size {
 			return errDomainSizeOrder
 		}
+		if err := index.checkSignature(rec.Signature); err != nil {
+			return err
+		}
 		currSize = rec.Size
 		if currSize > index.Partitions[currPart].Upper {
 			currPart++
@@ -28,7 +31,7 @@ func bootstrapOptimal(index *LshEnsemble, sortedDomains <-chan *DomainRecord) er
 				currSize <= index.Partitions[currPart].Upper) {
 			return errors.New("Domain records does not match the existing partitions")
 		}
-		index.Add(rec.Key, rec.Signature, currPart)
+		index.add(rec.Key, rec.Signature, rec.Size, currPart)
 	}
 	index.Index()
 	return nil
@@ -43,10 +46,11 @@ func bootstrapOptimal(index *LshEnsemble, sortedDomains <-chan *DomainRecord) er
 // functions per "band".
 // sortedDomainFactory is factory function that returns a DomainRecord channel
 // emitting domains in sorted order by their sizes.
-func BootstrapLshEnsembleOptimal(numPart, numHash, maxK int,
-	sortedDomainFactory func() <-chan *DomainRecord) (*LshEnsemble, error) {
+// opts are the options for creating the index.
+func BootstrapLshEnsembleOptimal[K comparable](numPart, numHash, maxK int,
+	sortedDomainFactory func() <-chan *DomainRecord[K], opts ...Option) (*LshEnsemble[K], error) {
 	partitions, count := bootstrapOptimalPartitions(sortedDomainFactory(), numPart)
-	index := NewLshEnsemble(partitions, numHash, maxK, count)
+	index := NewLshEnsemble[K](partitions, numHash, maxK, count, opts...)
 	err := bootstrapOptimal(index, sortedDomainFactory())
 	if err != nil {
 		return nil, err
@@ -63,10 +67,11 @@ func BootstrapLshEnsembleOptimal(numPart, numHash, maxK int,
 // functions per "band".
 // sortedDomainFactory is factory function that returns a DomainRecord channel
 // emitting domains in sorted order by their sizes.
-func BootstrapLshEnsemblePlusOptimal(numPart, numHash, maxK int,
-	sortedDomainFactory func() <-chan *DomainRecord) (*LshEnsemble, error) {
+// opts are the options for creating the index.
+func BootstrapLshEnsemblePlusOptimal[K comparable](numPart, numHash, maxK int,
+	sortedDomainFactory func() <-chan *DomainRecord[K], opts ...Option) (*LshEnsemble[K], error) {
 	partitions, count := bootstrapOptimalPartitions(sortedDomainFactory(), numPart)
-	index := NewLshEnsemblePlus(partitions, numHash, maxK, count)
+	index := NewLshEnsemblePlus[K](partitions, numHash, maxK, count, opts...)
 	err := bootstrapOptimal(index, sortedDomainFactory())
 	if err != nil {
 		return nil, err
@@ -74,7 +79,29 @@ func BootstrapLshEnsemblePlusOptimal(numPart, numHash, maxK int,
 	return index, nil
 }
 
-func bootstrapEquiDepth(index *LshEnsemble, totalNumDomains int, sortedDomains <-chan *DomainRecord) error {
+// BootstrapLshEnsembleBandedOptimal builds an index from domains using optimal
+// partitioning.
+// The returned index consists of MinHash LSH implemented using BandedLsh,
+// for queries with containment thresholds no less than minThreshold.
+// numPart is the number of partitions to create.
+// numHash is the number of hash functions in MinHash.
+// maxK is the maximum value for the MinHash parameter K - the number of hash
+// functions per "band".
+// sortedDomainFactory is factory function that returns a DomainRecord channel
+// emitting domains in sorted order by their sizes.
+// opts are the options for creating the index.
+func BootstrapLshEnsembleBandedOptimal[K comparable](numPart, numHash, maxK int, minThreshold float64,
+	sortedDomainFactory func() <-chan *DomainRecord[K], opts ...Option) (*LshEnsemble[K], error) {
+	partitions, count := bootstrapOptimalPartitions(sortedDomainFactory(), numPart)
+	index := NewLshEnsembleBanded[K](partitions, numHash, maxK, minThreshold, count, opts...)
+	err := bootstrapOptimal(index, sortedDomainFactory())
+	if err != nil {
+		return nil, err
+	}
+	return index, nil
+}
+
+func bootstrapEquiDepth[K comparable](index *LshEnsemble[K], totalNumDomains int, sortedDomains <-chan *DomainRecord[K]) error {
 	numPart := len(index.Partitions)
 	depth := totalNumDomains / numPart
 	var currDepth, currPart int
@@ -83,8 +110,11 @@ func bootstrapEquiDepth(index *LshEnsemble, totalNumDomains int, sortedDomains <
 		if currSize > rec.Size {
 			return errDomainSizeOrder
 		}
+		if err := index.checkSignature(rec.Signature); err != nil {
+			return err
+		}
 		currSize = rec.Size
-		index.Add(rec.Key, rec.Signature, currPart)
+		index.add(rec.Key, rec.Signature, rec.Size, currPart)
 		currDepth++
 		index.Partitions[currPart].Upper = rec.Size
 		if currDepth >= depth && currPart < numPart-1 {
@@ -105,10 +135,11 @@ func bootstrapEquiDepth(index *LshEnsemble, totalNumDomains int, sortedDomains <
 // numHash is the number of hash functions in MinHash.
 // maxK is the maximum value for the MinHash parameter K - the number of hash functions per "band".
 // sortedDomains is a DomainRecord channel emitting domains in sorted order by their sizes.
-func BootstrapLshEnsembleEquiDepth(numPart, numHash, maxK, totalNumDomains int,
-	sortedDomains <-chan *DomainRecord) (*LshEnsemble, error) {
-	index := NewLshEnsemble(make([]Partition, numPart), numHash, maxK,
-		totalNumDomains)
+// opts are the options for creating the index.
+func BootstrapLshEnsembleEquiDepth[K comparable](numPart, numHash, maxK, totalNumDomains int,
+	sortedDomains <-chan *DomainRecord[K], opts ...Option) (*LshEnsemble[K], error) {
+	index := NewLshEnsemble[K](make([]Partition, numPart), numHash, maxK,
+		totalNumDomains, opts...)
 	err := bootstrapEquiDepth(index, totalNumDomains, sortedDomains)
 	if err != nil {
 		return nil, err
@@ -124,10 +155,11 @@ func BootstrapLshEnsembleEquiDepth(numPart, numHash, maxK, totalNumDomains int,
 // numHash is the number of hash functions in MinHash.
 // maxK is the maximum value for the MinHash parameter K - the number of hash functions per "band".
 // sortedDomains is a DomainRecord channel emitting domains in sorted order by their sizes.
-func BootstrapLshEnsemblePlusEquiDepth(numPart, numHash, maxK,
-	totalNumDomains int, sortedDomains <-chan *DomainRecord) (*LshEnsemble, error) {
-	index := NewLshEnsemblePlus(make([]Partition, numPart), numHash, maxK,
-		totalNumDomains)
+// opts are the options for creating the index.
+func BootstrapLshEnsemblePlusEquiDepth[K comparable](numPart, numHash, maxK,
+	totalNumDomains int, sortedDomains <-chan *DomainRecord[K], opts ...Option) (*LshEnsemble[K], error) {
+	index := NewLshEnsemblePlus[K](make([]Partition, numPart), numHash, maxK,
+		totalNumDomains, opts...)
 	err := bootstrapEquiDepth(index, totalNumDomains, sortedDomains)
 	if err != nil {
 		return nil, err
@@ -136,8 +168,8 @@ func BootstrapLshEnsemblePlusEquiDepth(numPart, numHash, maxK,
 }
 
 // Recs2Chan is a utility function that converts a DomainRecord slice in memory to a DomainRecord channel.
-func Recs2Chan(recs []*DomainRecord) <-chan *DomainRecord {
-	c := make(chan *DomainRecord, 1000)
+func Recs2Chan[K comparable](recs []*DomainRecord[K]) <-chan *DomainRecord[K] {
+	c := make(chan *DomainRecord[K], 1000)
 	go func() {
 		for _, r := range recs {
 			c <- r
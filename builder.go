@@ -0,0 +1,159 @@
+package lshensemble
+
+import (
+	"context"
+	"iter"
+	"sync"
+)
+
+// builderCancelInterval is the number of values a SignatureBuilder worker
+// pushes between checks for cancellation.
+const builderCancelInterval = 1024
+
+// RawDomain is a domain to be sketched by a SignatureBuilder.
+type RawDomain[K comparable] struct {
+	// The unique key of this domain.
+	Key K
+	// Values iterates over the values of this domain, duplicates
+	// are allowed.
+	Values iter.Seq[[]byte]
+}
+
+// SignatureBuilder computes the MinHash signatures and sizes of raw
+// domains in parallel, and emits domain records ready for indexing.
+type SignatureBuilder[K comparable] struct {
+	// Seed and NumHash are the seed and number of hash functions of
+	// the MinHash signatures.
+	Seed    int64
+	NumHash int
+	// Hash configures the hash functions of the MinHash signatures.
+	Hash MinhashOptions
+	// Normalizer normalizes the values before they are hashed and
+	// counted, nil to use the values as they are. Use the Normalizer of
+	// the index when building query domains.
+	Normalizer *Normalizer
+	// NumWorkers is the number of goroutines computing signatures.
+	NumWorkers int
+	// EstimateSizes makes the builder estimate the domain sizes using
+	// Minhash.Cardinality, instead of counting the distinct values,
+	// which requires keeping the distinct values of a domain in memory.
+	EstimateSizes bool
+}
+
+// NewSignatureBuilder initializes a SignatureBuilder with the seed and
+// number of hash functions of the signatures, and the number of workers.
+func NewSignatureBuilder[K comparable](seed int64, numHash, numWorkers int) *SignatureBuilder[K] {
+	return &SignatureBuilder[K]{
+		Seed:       seed,
+		NumHash:    numHash,
+		NumWorkers: numWorkers,
+	}
+}
+
+// Build reads raw domains from the channel domains, and emits their domain
+// records in the returned channel, in no particular order. The records
+// must be sorted by size before bootstrapping an index.
+// A new domain is read only when a worker is free, and a worker waits until
+// its record is received, so a slow consumer slows down the builder rather
+// than making it buffer records.
+// The returned channel is closed when all domains are built, or when ctx
+// is done, in which case domains being built are discarded.
+// An error is returned without reading any domain if the custom function of
+// the Normalizer is not registered.
+func (b *SignatureBuilder[K]) Build(ctx context.Context, domains <-chan RawDomain[K]) (<-chan *DomainRecord[K], error) {
+	if err := b.Normalizer.Validate(); err != nil {
+		return nil, err
+	}
+	numWorkers := b.NumWorkers
+	if numWorkers < 1 {
+		numWorkers = 1
+	}
+	out := make(chan *DomainRecord[K])
+	var wg sync.WaitGroup
+	wg.Add(numWorkers)
+	for w := 0; w < numWorkers; w++ {
+		go func() {
+			defer wg.Done()
+			for {
+				var domain RawDomain[K]
+				var ok bool
+				select {
+				case domain, ok = <-domains:
+				case <-ctx.Done():
+					return
+				}
+				if !ok {
+					return
+				}
+				rec, ok := b.build(ctx, domain)
+				if !ok {
+					return
+				}
+				select {
+				case out <- rec:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(out)
+	}()
+	return out, nil
+}
+
+// BuildSeq is similar to Build, but reads the keys and values of the raw
+// domains from an iterator. The iterator runs in its own goroutine, and
+// the values of a domain are iterated by a worker goroutine.
+func (b *SignatureBuilder[K]) BuildSeq(ctx context.Context, domains iter.Seq2[K, iter.Seq[[]byte]]) (<-chan *DomainRecord[K], error) {
+	if err := b.Normalizer.Validate(); err != nil {
+		return nil, err
+	}
+	in := make(chan RawDomain[K])
+	go func() {
+		defer close(in)
+		for key, values := range domains {
+			select {
+			case in <- RawDomain[K]{key, values}:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return b.Build(ctx, in)
+}
+
+// build computes the domain record of a raw domain, it returns false if
+// ctx is done before the values are exhausted.
+func (b *SignatureBuilder[K]) build(ctx context.Context, domain RawDomain[K]) (*DomainRecord[K], bool) {
+	opts := b.Hash
+	opts.EstimateCardinality = b.EstimateSizes
+	mh := NewMinhashWithOptions(b.Seed, b.NumHash, opts)
+	var distinct map[string]struct{}
+	if !b.EstimateSizes {
+		distinct = make(map[string]struct{})
+	}
+	var n int
+	for v := range domain.Values {
+		v = b.Normalizer.Normalize(v)
+		mh.Push(v)
+		if distinct != nil {
+			distinct[string(v)] = struct{}{}
+		}
+		n++
+		if n%builderCancelInterval == 0 && ctx.Err() != nil {
+			return nil, false
+		}
+	}
+	size := len(distinct)
+	if b.EstimateSizes {
+		size = mh.Cardinality()
+	}
+	return &DomainRecord[K]{
+		Key:       domain.Key,
+		Size:      size,
+		Signature: mh.Signature(),
+	}, true
+}
@@ -0,0 +1,98 @@
+package lshensemble
+
+import (
+	"context"
+	"iter"
+	"strconv"
+	"testing"
+)
+
+func rawValues(start, end int) iter.Seq[[]byte] {
+	return func(yield func([]byte) bool) {
+		for i := start; i < end; i++ {
+			// Every value appears twice.
+			for j := 0; j < 2; j++ {
+				if !yield([]byte(strconv.Itoa(i))) {
+					return
+				}
+			}
+		}
+	}
+}
+
+func Test_SignatureBuilder(t *testing.T) {
+	domains := func(yield func(string, iter.Seq[[]byte]) bool) {
+		for i := 1; i <= 50; i++ {
+			if !yield(strconv.Itoa(i), rawValues(0, i*10)) {
+				return
+			}
+		}
+	}
+	b := NewSignatureBuilder[string](1, 64, 4)
+	recs := make(map[string]*DomainRecord[string])
+	out, err := b.BuildSeq(context.Background(), domains)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for rec := range out {
+		recs[rec.Key] = rec
+	}
+	if len(recs) != 50 {
+		t.Fatalf("Built %d records, expecting 50", len(recs))
+	}
+	for i := 1; i <= 50; i++ {
+		rec := recs[strconv.Itoa(i)]
+		if rec.Size != i*10 {
+			t.Errorf("Domain %s has size %d, expecting %d", rec.Key, rec.Size, i*10)
+		}
+		mh := NewMinhash(1, 64)
+		for v := range rawValues(0, i*10) {
+			mh.Push(v)
+		}
+		for j, v := range mh.Signature() {
+			if rec.Signature[j] != v {
+				t.Fatalf("Domain %s has a different signature", rec.Key)
+			}
+		}
+	}
+
+	b.EstimateSizes = true
+	in := make(chan RawDomain[string], 1)
+	in <- RawDomain[string]{"a", rawValues(0, 1000)}
+	close(in)
+	out, err = b.Build(context.Background(), in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec := <-out
+	if rec.Size < 900 || rec.Size > 1100 {
+		t.Errorf("Estimated size %d, actual 1000", rec.Size)
+	}
+}
+
+func Test_SignatureBuilderCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	in := make(chan RawDomain[int])
+	go func() {
+		defer close(in)
+		for i := 0; ; i++ {
+			select {
+			case in <- RawDomain[int]{i, rawValues(0, 10)}:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	out, err := NewSignatureBuilder[int](1, 16, 2).Build(ctx, in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 10; i++ {
+		<-out
+	}
+	cancel()
+	// The output is closed after the cancellation, without reading
+	// the endless input.
+	for range out {
+	}
+}
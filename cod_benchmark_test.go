@@ -210,8 +210,8 @@ func readDomains(dir string) chan rawDomain {
 }
 
 type queryResult struct {
-	candidates []interface{}
-	queryKey   interface{}
+	candidates []string
+	queryKey   string
 	duration   time.Duration
 }
 
@@ -222,12 +222,12 @@ func outputQueryResults(results chan queryResult, outputFilename string) {
 	}
 	out := bufio.NewWriter(f)
 	for result := range results {
-		out.WriteString(result.queryKey.(string))
+		out.WriteString(result.queryKey)
 		out.WriteString("\t")
 		out.WriteString(result.duration.String())
 		out.WriteString("\t")
 		for i, candidate := range result.candidates {
-			out.WriteString(candidate.(string))
+			out.WriteString(candidate)
 			if i < len(result.candidates)-1 {
 				out.WriteString("\t")
 			}
@@ -0,0 +1,122 @@
+package lshensemble
+
+import (
+	"crypto/sha1"
+	"encoding/binary"
+)
+
+const (
+	// datasketchPrime is the Mersenne prime 2^61 - 1 used by the
+	// permutations of datasketch.
+	datasketchPrime = 1<<61 - 1
+	// datasketchMaxHash is the maximum hash value of datasketch.
+	datasketchMaxHash = 1<<32 - 1
+)
+
+// datasketchMinhash computes signatures compatible with datasketch.MinHash
+// of the datasketch Python library (https://github.com/ekzhu/datasketch),
+// given the same seed and number of permutations. Every value is hashed
+// to 32 bits using SHA1, and permuted by (a * hv + b) % (2^61 - 1)
+// truncated to 32 bits, where a and b are drawn from numpy's legacy
+// RandomState seeded by the seed.
+type datasketchMinhash struct {
+	a, b       []uint64
+	hashValues []uint64
+	hll        *HyperLogLog
+}
+
+func newDatasketchMinhash(seed int64, numHash int, hll *HyperLogLog) *datasketchMinhash {
+	m := &datasketchMinhash{
+		a:          make([]uint64, numHash),
+		b:          make([]uint64, numHash),
+		hashValues: make([]uint64, numHash),
+		hll:        hll,
+	}
+	// datasketch draws a and b of every permutation in turn, using
+	// RandomState.randint(1, prime) and RandomState.randint(0, prime).
+	mt := newMT19937(uint32(seed))
+	for i := range m.a {
+		m.a[i] = 1 + mt.boundedUint64(datasketchPrime-2)
+		m.b[i] = mt.boundedUint64(datasketchPrime - 1)
+		m.hashValues[i] = datasketchMaxHash
+	}
+	return m
+}
+
+func (m *datasketchMinhash) push(b []byte) {
+	sum := sha1.Sum(b)
+	hv := uint64(binary.LittleEndian.Uint32(sum[:4]))
+	if m.hll != nil {
+		m.hll.addHash(splitMix64(hv))
+	}
+	for i := range m.hashValues {
+		// The multiplication overflows the same way as numpy's uint64.
+		phv := (m.a[i]*hv + m.b[i]) % datasketchPrime & datasketchMaxHash
+		if phv < m.hashValues[i] {
+			m.hashValues[i] = phv
+		}
+	}
+}
+
+func (m *datasketchMinhash) merge(other *datasketchMinhash) {
+	for i, v := range other.hashValues {
+		if v < m.hashValues[i] {
+			m.hashValues[i] = v
+		}
+	}
+}
+
+// mt19937 is the Mersenne Twister generator as seeded by numpy's legacy
+// RandomState given an integer seed.
+type mt19937 struct {
+	state [624]uint32
+	pos   int
+}
+
+func newMT19937(seed uint32) *mt19937 {
+	mt := &mt19937{pos: len(mt19937{}.state)}
+	mt.state[0] = seed
+	for i := 1; i < len(mt.state); i++ {
+		prev := mt.state[i-1]
+		mt.state[i] = 1812433253*(prev^(prev>>30)) + uint32(i)
+	}
+	return mt
+}
+
+func (mt *mt19937) next32() uint32 {
+	const n, m = 624, 397
+	if mt.pos >= n {
+		for i := 0; i < n; i++ {
+			y := mt.state[i]&0x80000000 | mt.state[(i+1)%n]&0x7fffffff
+			v := mt.state[(i+m)%n] ^ y>>1
+			if y&1 == 1 {
+				v ^= 0x9908b0df
+			}
+			mt.state[i] = v
+		}
+		mt.pos = 0
+	}
+	y := mt.state[mt.pos]
+	mt.pos++
+	y ^= y >> 11
+	y ^= y << 7 & 0x9d2c5680
+	y ^= y << 15 & 0xefc60000
+	y ^= y >> 18
+	return y
+}
+
+// boundedUint64 returns a random integer in [0, rng] using the masked
+// rejection sampling of numpy's legacy RandomState.randint, for
+// rng > 2^32 - 1.
+func (mt *mt19937) boundedUint64(rng uint64) uint64 {
+	mask := rng
+	for s := uint(1); s < 64; s <<= 1 {
+		mask |= mask >> s
+	}
+	for {
+		v := (uint64(mt.next32())<<32 | uint64(mt.next32())) & mask
+		if v <= rng {
+			return v
+		}
+	}
+}
@@ -1,28 +1,42 @@
 package lshensemble
 
 import (
+	"math"
 	"sort"
 )
 
 // DomainRecord represents a domain record.
-type DomainRecord struct {
+type DomainRecord[K comparable] struct {
 	// The unique key of this domain.
-	Key interface{}
+	Key K
 	// The domain size.
 	Size int
 	// The MinHash signature of this domain.
 	Signature []uint64
 }
 
+// NewWeightedDomainRecord creates a domain record from a weighted MinHash
+// object. The domain size is the total weight rounded up, so the index
+// partitions the domains and estimates the containment using the weights:
+// Containment with the sizes estimates the same as WeightedContainment
+// with the rounded weights.
+func NewWeightedDomainRecord[K comparable](key K, m *WeightedMinhash) *DomainRecord[K] {
+	return &DomainRecord[K]{
+		Key:       key,
+		Size:      int(math.Ceil(m.TotalWeight())),
+		Signature: m.Signature(),
+	}
+}
+
 // BySize is a wrapper for sorting domains.
-type BySize []*DomainRecord
+type BySize[K comparable] []*DomainRecord[K]
 
-func (rs BySize) Len() int           { return len(rs) }
-func (rs BySize) Less(i, j int) bool { return rs[i].Size < rs[j].Size }
-func (rs BySize) Swap(i, j int)      { rs[i], rs[j] = rs[j], rs[i] }
+func (rs BySize[K]) Len() int           { return len(rs) }
+func (rs BySize[K]) Less(i, j int) bool { return rs[i].Size < rs[j].Size }
+func (rs BySize[K]) Swap(i, j int)      { rs[i], rs[j] = rs[j], rs[i] }
 
 // Subset returns a subset of the domains given the size lower bound and upper bound.
-func (rs BySize) Subset(lower, upper int) []*DomainRecord {
+func (rs BySize[K]) Subset(lower, upper int) []*DomainRecord[K] {
 	if !sort.IsSorted(rs) {
 		panic("Must be sorted by domain size first")
 	}
@@ -42,5 +56,5 @@ func (rs BySize) Subset(lower, upper int) []*DomainRecord {
 	if end == len(rs)-1 {
 		end++
 	}
-	return []*DomainRecord(rs[start:end])
+	return []*DomainRecord[K](rs[start:end])
 }
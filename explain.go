@@ -0,0 +1,101 @@
+package lshensemble
+
+import "sync"
+
+// PartitionPlan describes how a partition is searched for a query.
+type PartitionPlan struct {
+	Partition
+	// K and L are the LSH parameters selected for the partition,
+	// both are zero if the partition is pruned.
+	K int
+	L int
+	// FalsePositive and FalseNegative are the predicted false positive
+	// and false negative probabilities using K and L.
+	FalsePositive float64
+	FalseNegative float64
+	// NumDomains is the number of searchable domains in the partition.
+	NumDomains int
+	// CanMatch is false if no domain in the partition can meet the
+	// containment threshold, either because the partition is empty, or
+	// because its domains are too small.
+	CanMatch bool
+	// Pruned is true if the partition is skipped by the query because
+	// its domains are too small to meet the containment threshold.
+	Pruned bool
+	// NumCandidates is the number of candidates emitted by the partition,
+	// only set by QueryExplain.
+	NumCandidates int
+}
+
+// canMatch returns whether a partition may contain domains meeting the
+// containment threshold of a query with the given size. Since
+// |Q \intersect X| <= |X|, a domain X smaller than threshold * |Q|
+// can never meet the threshold.
+func canMatch(upper, size int, threshold float64) bool {
+	return float64(upper) >= threshold*float64(size)
+}
+
+// Explain returns the query plan of every partition for a query with the
+// given size and containment threshold, without running the query.
+func (e *LshEnsemble[K]) Explain(size int, threshold float64) []PartitionPlan {
+	parts, lshes := e.layout()
+	return e.explain(parts, lshes, size, threshold)
+}
+
+func (e *LshEnsemble[K]) explain(parts []Partition, lshes []Lsh[K], size int, threshold float64) []PartitionPlan {
+	params := e.computeParams(parts, lshes, size, threshold)
+	plans := make([]PartitionPlan, len(parts))
+	for i, p := range parts {
+		numDomains := lshes[i].NumKeys()
+		plans[i] = PartitionPlan{
+			Partition:     p,
+			K:             params[i].k,
+			L:             params[i].l,
+			FalsePositive: params[i].fp,
+			FalseNegative: params[i].fn,
+			NumDomains:    numDomains,
+			CanMatch:      numDomains > 0 && !params[i].pruned,
+			Pruned:        params[i].pruned,
+		}
+	}
+	return plans
+}
+
+// QueryExplain is similar to QueryTimed, returns the candidate domain keys
+// in a slice, as well as the query plan of every partition including the
+// number of candidates each partition emitted.
+// An error is returned if the signature does not match the index.
+func (e *LshEnsemble[K]) QueryExplain(sig []uint64, size int, threshold float64) ([]K, []PartitionPlan, error) {
+	if err := e.checkSignature(sig); err != nil {
+		return nil, nil, err
+	}
+	parts, lshes := e.layout()
+	plans := e.explain(parts, lshes, size, threshold)
+	result := make([]K, 0)
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for i := range lshes {
+		if plans[i].Pruned {
+			continue
+		}
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			out := make(chan K)
+			go func() {
+				lshes[i].Query(sig, plans[i].K, plans[i].L, out, nil)
+				close(out)
+			}()
+			var keys []K
+			for key := range out {
+				keys = append(keys, key)
+			}
+			plans[i].NumCandidates = len(keys)
+			mu.Lock()
+			result = append(result, keys...)
+			mu.Unlock()
+		}(i)
+	}
+	wg.Wait()
+	return result, plans, nil
+}
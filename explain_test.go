@@ -0,0 +1,79 @@
+package lshensemble
+
+import "testing"
+
+func Test_LshEnsembleExplain(t *testing.T) {
+	recs := testDomainRecords(100, 64)
+	index, err := BootstrapLshEnsembleEquiDepth(4, 64, 4, len(recs), Recs2Chan(recs))
+	if err != nil {
+		t.Fatal(err)
+	}
+	query := recs[len(recs)-1]
+	plans := index.Explain(query.Size, 0.5)
+	if len(plans) != len(index.Partitions) {
+		t.Fatal(plans)
+	}
+	var numDomains int
+	for _, plan := range plans {
+		numDomains += plan.NumDomains
+		if !plan.Pruned && (plan.K < 1 || plan.L < 1) {
+			t.Fatalf("Invalid parameters %v", plan)
+		}
+		if plan.CanMatch != (float64(plan.Upper) >= 0.5*float64(query.Size)) {
+			t.Fatalf("Incorrect CanMatch %v", plan)
+		}
+	}
+	if numDomains != len(recs) {
+		t.Fatalf("Expected %d domains, got %d", len(recs), numDomains)
+	}
+
+	result, plans, err := index.QueryExplain(query.Signature, query.Size, 0.5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var numCandidates int
+	for _, plan := range plans {
+		numCandidates += plan.NumCandidates
+	}
+	if numCandidates != len(result) {
+		t.Fatalf("Expected %d candidates, got %d", len(result), numCandidates)
+	}
+}
+
+func Test_LshEnsemblePruning(t *testing.T) {
+	recs := testDomainRecords(100, 64)
+	index, err := BootstrapLshEnsembleEquiDepth(4, 64, 4, len(recs), Recs2Chan(recs))
+	if err != nil {
+		t.Fatal(err)
+	}
+	query := recs[len(recs)-1]
+	numPruned := index.NumPruned(query.Size, 0.9)
+	if numPruned == 0 {
+		t.Fatal("Expected partitions of small domains to be pruned")
+	}
+	result, plans, err := index.QueryExplain(query.Signature, query.Size, 0.9)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var n int
+	for _, plan := range plans {
+		if plan.Pruned {
+			n++
+			if plan.NumCandidates != 0 {
+				t.Fatal("Pruned partition emitted candidates")
+			}
+		}
+	}
+	if n != numPruned {
+		t.Fatalf("Expected %d pruned partitions, got %d", numPruned, n)
+	}
+	var found bool
+	for _, key := range result {
+		if key == query.Key {
+			found = true
+		}
+	}
+	if !found {
+		t.Fatal("unable to retrieve inserted key")
+	}
+}
@@ -3,8 +3,11 @@ module lshensemble
 go 1.23.5
 
 require (
+	github.com/cespare/xxhash/v2 v2.3.0
 	github.com/dgryski/go-minhash v0.0.0-20190315135803-ad340ca03076
 	github.com/orcaman/concurrent-map v1.0.0
+	github.com/spaolacci/murmur3 v1.1.0
+	golang.org/x/text v0.21.0
 )
 
 require (
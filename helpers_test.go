@@ -0,0 +1,41 @@
+package lshensemble
+
+import (
+	"sort"
+	"strconv"
+	"testing"
+)
+
+func testDomainRecords(n, numHash int) []*DomainRecord[string] {
+	recs := make([]*DomainRecord[string], n)
+	for i := range recs {
+		mh := NewMinhash(1, numHash)
+		size := i%20 + 1
+		for v := 0; v < size; v++ {
+			mh.Push([]byte(strconv.Itoa(i*100 + v)))
+		}
+		recs[i] = &DomainRecord[string]{
+			Key:       strconv.Itoa(i),
+			Size:      size,
+			Signature: mh.Signature(),
+		}
+	}
+	sort.Sort(BySize[string](recs))
+	return recs
+}
+
+// testIndexes bootstraps an index using LshForest and an index using
+// LshForestArray from the records of 64 hash functions, both with 4
+// equi-depth partitions and maxK = 4.
+func testIndexes(t *testing.T, recs []*DomainRecord[string]) []*LshEnsemble[string] {
+	t.Helper()
+	index, err := BootstrapLshEnsembleEquiDepth(4, 64, 4, len(recs), Recs2Chan(recs))
+	if err != nil {
+		t.Fatal(err)
+	}
+	plus, err := BootstrapLshEnsemblePlusEquiDepth(4, 64, 4, len(recs), Recs2Chan(recs))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return []*LshEnsemble[string]{index, plus}
+}
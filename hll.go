@@ -0,0 +1,135 @@
+package lshensemble
+
+import (
+	"errors"
+	"hash/fnv"
+	"math"
+	"math/bits"
+)
+
+// sizeErrorMargin is the number of standard errors by which estimated
+// domain sizes are widened, so domains whose actual sizes meet the
+// containment threshold are rarely missed.
+const sizeErrorMargin = 2.0
+
+// minhashHllPrecision is the precision of the HyperLogLog computed by
+// Minhash, its relative standard error is about 1.6%.
+const minhashHllPrecision = 12
+
+var errPrecisionMismatch = errors.New("Cannot merge HyperLogLog with different precisions")
+
+// HyperLogLog estimates the number of distinct values in a domain
+// (http://algo.inria.fr/flajolet/Publications/FlFuGaMe07.pdf)
+// using 2^precision bytes of memory, so the domain values do not need to
+// be kept in memory to compute the exact size.
+type HyperLogLog struct {
+	precision uint
+	registers []uint8
+}
+
+// NewHyperLogLog initializes a HyperLogLog with a precision between 4
+// and 16. A higher precision has lower error and uses more memory.
+func NewHyperLogLog(precision int) *HyperLogLog {
+	if precision < 4 || precision > 16 {
+		panic("precision must be between 4 and 16")
+	}
+	return &HyperLogLog{
+		precision: uint(precision),
+		registers: make([]uint8, 1<<uint(precision)),
+	}
+}
+
+// Push a new value to the HyperLogLog.
+// The value should be serialized to byte slice.
+func (h *HyperLogLog) Push(b []byte) {
+	f := fnv.New64a()
+	f.Write(b)
+	h.addHash(splitMix64(f.Sum64()))
+}
+
+// addHash adds a uniformly distributed hash value.
+func (h *HyperLogLog) addHash(v uint64) {
+	i := v >> (64 - h.precision)
+	// The rank is the position of the leftmost 1-bit in the remaining bits.
+	rank := uint8(bits.LeadingZeros64(v<<h.precision|1<<(h.precision-1)) + 1)
+	if rank > h.registers[i] {
+		h.registers[i] = rank
+	}
+}
+
+// Merge combines another HyperLogLog, so it estimates the size of the
+// union of the two domains. The precisions must be the same.
+func (h *HyperLogLog) Merge(other *HyperLogLog) error {
+	if h.precision != other.precision {
+		return errPrecisionMismatch
+	}
+	for i, r := range other.registers {
+		if r > h.registers[i] {
+			h.registers[i] = r
+		}
+	}
+	return nil
+}
+
+// Cardinality returns the estimated number of distinct values.
+func (h *HyperLogLog) Cardinality() int {
+	m := float64(len(h.registers))
+	var sum float64
+	var zeros int
+	for _, r := range h.registers {
+		sum += math.Ldexp(1.0, -int(r))
+		if r == 0 {
+			zeros++
+		}
+	}
+	var alpha float64
+	switch len(h.registers) {
+	case 16:
+		alpha = 0.673
+	case 32:
+		alpha = 0.697
+	case 64:
+		alpha = 0.709
+	default:
+		alpha = 0.7213 / (1.0 + 1.079/m)
+	}
+	est := alpha * m * m / sum
+	// Use linear counting for small cardinalities.
+	if est <= 2.5*m && zeros > 0 {
+		est = m * math.Log(m/float64(zeros))
+	}
+	return int(math.Round(est))
+}
+
+// RelativeError returns the relative standard error of the estimated
+// cardinality.
+func (h *HyperLogLog) RelativeError() float64 {
+	return 1.04 / math.Sqrt(float64(len(h.registers)))
+}
+
+// EstimatedSizes indicates that the domain sizes, including the query
+// sizes, are estimated with the given relative standard error, for
+// example using Minhash.Cardinality. The query parameters are optimized
+// for domain sizes that may be larger and query sizes that may be smaller
+// than the estimates, so fewer domains are missed due to estimation error.
+// The option is saved by WriteTo.
+func EstimatedSizes(relativeError float64) Option {
+	return func(o *options) {
+		o.sizeError = relativeError
+	}
+}
+
+// widenSizes returns the largest likely domain size x and smallest likely
+// query size q given their estimates.
+func (e *LshEnsemble[K]) widenSizes(x, q int) (int, int) {
+	if e.sizeError <= 0 {
+		return x, q
+	}
+	margin := math.Min(sizeErrorMargin*e.sizeError, 0.5)
+	wx := int(math.Ceil(float64(x) * (1.0 + margin)))
+	wq := int(math.Floor(float64(q) * (1.0 - margin)))
+	if wq < 1 && q > 0 {
+		wq = 1
+	}
+	return wx, wq
+}
@@ -0,0 +1,99 @@
+package lshensemble
+
+import (
+	"bytes"
+	"math"
+	"strconv"
+	"testing"
+)
+
+func Test_HyperLogLog(t *testing.T) {
+	for _, n := range []int{10, 1000, 100000} {
+		h := NewHyperLogLog(minhashHllPrecision)
+		for i := 0; i < n; i++ {
+			h.Push([]byte(strconv.Itoa(i)))
+			// Duplicates do not change the estimate.
+			h.Push([]byte(strconv.Itoa(i)))
+		}
+		est := h.Cardinality()
+		if err := math.Abs(float64(est-n)) / float64(n); err > 4*h.RelativeError() {
+			t.Errorf("Estimated cardinality %d, actual %d", est, n)
+		}
+	}
+}
+
+func Test_MinhashCardinality(t *testing.T) {
+	d := data(5000)
+	opts := MinhashOptions{EstimateCardinality: true}
+	m1 := NewMinhashWithOptions(1, 64, opts)
+	m2 := NewMinhashWithOptions(1, 64, opts)
+	if NewMinhash(1, 64).hll != nil {
+		t.Fatal("Minhash estimates the cardinality without EstimateCardinality")
+	}
+	if err := m1.Merge(NewMinhash(1, 64)); err != errHllMismatch {
+		t.Fatalf("Merge returned %v, expecting %v", err, errHllMismatch)
+	}
+	hashing(m1, 0, 3000, d)
+	hashing(m2, 2000, 5000, d)
+	if err := math.Abs(float64(m1.Cardinality()-3000)) / 3000; err > 4*m1.CardinalityError() {
+		t.Errorf("Estimated cardinality %d, actual 3000", m1.Cardinality())
+	}
+	if err := m1.Merge(m2); err != nil {
+		t.Fatal(err)
+	}
+	if err := math.Abs(float64(m1.Cardinality()-5000)) / 5000; err > 4*m1.CardinalityError() {
+		t.Errorf("Estimated cardinality of the union %d, actual 5000", m1.Cardinality())
+	}
+}
+
+func Test_LshEnsembleEstimatedSizes(t *testing.T) {
+	recs := testDomainRecords(100, 64)
+	exact, err := BootstrapLshEnsembleEquiDepth(4, 64, 4, len(recs), Recs2Chan(recs))
+	if err != nil {
+		t.Fatal(err)
+	}
+	estimated, err := BootstrapLshEnsembleEquiDepth(4, 64, 4, len(recs), Recs2Chan(recs),
+		EstimatedSizes(0.1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A domain slightly smaller than the threshold may still match if its
+	// size is underestimated.
+	size, threshold := 20, 0.9
+	if estimated.NumPruned(size, threshold) >= exact.NumPruned(size, threshold) {
+		t.Error("Estimated sizes should prune fewer partitions than exact sizes")
+	}
+	// The option is saved with the index.
+	var buf bytes.Buffer
+	if _, err := estimated.WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := ReadLshEnsemble[string](bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	mapped, err := readMappedLshEnsemble[string](buf.Bytes(), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range []*LshEnsemble[string]{loaded, mapped} {
+		if e.NumPruned(size, threshold) != estimated.NumPruned(size, threshold) {
+			t.Error("Loaded index prunes differently from the saved index")
+		}
+	}
+	for _, rec := range recs {
+		found := false
+		candidates, _, err := estimated.QueryTimed(rec.Signature, rec.Size, 1.0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, key := range candidates {
+			if key == rec.Key {
+				found = true
+			}
+		}
+		if !found {
+			t.Fatalf("Query %s should find itself", rec.Key)
+		}
+	}
+}
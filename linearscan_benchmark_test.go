@@ -12,7 +12,7 @@ func benchmarkLinearscan(rawDomains []rawDomain, queries []rawDomain,
 	go func() {
 		for _, query := range queries {
 			start := time.Now()
-			r := make([]interface{}, 0)
+			r := make([]string, 0)
 			for _, domain := range rawDomains {
 				c := computeExactContainment(query.values, domain.values)
 				if c < threshold {
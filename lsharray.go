@@ -1,27 +1,27 @@
 package lshensemble
 
-import (
-	"math"
-)
-
 // LshForestArray represents a MinHash LSH implemented using an array of LshForest.
 // It allows a wider range for the K and L parameters.
-type LshForestArray struct {
+type LshForestArray[K comparable] struct {
 	maxK    int
 	numHash int
-	array   []*LshForest
+	array   []*LshForest[K]
 }
 
 // NewLshForestArray initializes with parameters:
 // maxK is the maximum value for the MinHash parameter K - the number of hash functions per "band".
 // numHash is the number of hash functions in MinHash.
 // initSize is the initial size of underlying hash tables to allocate.
-func NewLshForestArray(maxK, numHash, initSize int) *LshForestArray {
-	array := make([]*LshForest, maxK)
+func NewLshForestArray[K comparable](maxK, numHash, initSize int) *LshForestArray[K] {
+	return newLshForestArray[K](maxK, numHash, 32, initSize)
+}
+
+func newLshForestArray[K comparable](maxK, numHash, hashValueBits, initSize int) *LshForestArray[K] {
+	array := make([]*LshForest[K], maxK)
 	for k := 1; k <= maxK; k++ {
-		array[k-1] = NewLshForest(k, numHash/k, initSize)
+		array[k-1] = newLshForest[K](k, numHash/k, hashValueBits, initSize)
 	}
-	return &LshForestArray{
+	return &LshForestArray[K]{
 		maxK:    maxK,
 		numHash: numHash,
 		array:   array,
@@ -30,46 +30,67 @@ func NewLshForestArray(maxK, numHash, initSize int) *LshForestArray {
 
 // Add a key with MinHash signature into the index.
 // The key won't be searchable until Index() is called.
-func (a *LshForestArray) Add(key interface{}, sig []uint64) {
+func (a *LshForestArray[K]) Add(key K, sig []uint64) {
 	for i := range a.array {
 		a.array[i].Add(key, sig)
 	}
 }
 
+// Remove a key from the index. The key stops appearing in query results
+// immediately.
+func (a *LshForestArray[K]) Remove(key K) {
+	for i := range a.array {
+		a.array[i].Remove(key)
+	}
+}
+
+// Update replaces the MinHash signature of a key.
+// The new signature won't be searchable until Index() is called.
+func (a *LshForestArray[K]) Update(key K, sig []uint64) {
+	for i := range a.array {
+		a.array[i].Update(key, sig)
+	}
+}
+
 // Index makes all the keys added searchable.
-func (a *LshForestArray) Index() {
+func (a *LshForestArray[K]) Index() {
 	for i := range a.array {
 		a.array[i].Index()
 	}
 }
 
+// EnablePrefixDirectory makes the forests of the given numbers of hash
+// values per band keep prefix directories, see LshForest.EnablePrefixDirectory.
+// Calling it without any number removes the directories.
+func (a *LshForestArray[K]) EnablePrefixDirectory(ks ...int) {
+	enabled := make(map[int]bool)
+	for _, k := range ks {
+		enabled[k] = true
+	}
+	for i, f := range a.array {
+		if enabled[i+1] {
+			f.EnablePrefixDirectory(i + 1)
+		} else {
+			f.EnablePrefixDirectory()
+		}
+	}
+}
+
 // Query returns candidate keys given the query signature and parameters.
-func (a *LshForestArray) Query(sig []uint64, K, L int, out chan<- interface{}, done <-chan struct{}) {
-	a.array[K-1].Query(sig, -1, L, out, done)
+func (a *LshForestArray[K]) Query(sig []uint64, k, l int, out chan<- K, done <-chan struct{}) {
+	a.array[k-1].Query(sig, -1, l, out, done)
+}
+
+// NumKeys returns the number of searchable keys, which may include
+// removed keys that are not yet compacted.
+func (a *LshForestArray[K]) NumKeys() int {
+	return a.array[0].NumKeys()
 }
 
 // OptimalKL returns the optimal K and L for containment search,
 // and the false positive and negative probabilities.
 // where x is the indexed domain size, q is the query domain size,
 // and t is the containment threshold.
-func (a *LshForestArray) OptimalKL(x, q int, t float64) (optK, optL int, fp, fn float64) {
-	minError := math.MaxFloat64
-	for l := 1; l <= a.numHash; l++ {
-		for k := 1; k <= a.maxK; k++ {
-			if k*l > a.numHash {
-				continue
-			}
-			currFp := probFalsePositive(x, q, l, k, t, integrationPrecision)
-			currFn := probFalseNegative(x, q, l, k, t, integrationPrecision)
-			currErr := currFn + currFp
-			if minError > currErr {
-				minError = currErr
-				optK = k
-				optL = l
-				fp = currFp
-				fn = currFn
-			}
-		}
-	}
-	return
+func (a *LshForestArray[K]) OptimalKL(x, q int, t float64) (optK, optL int, fp, fn float64) {
+	return optimalKL(a.maxK, a.numHash, a.numHash, a.array[0].hashValueBits, x, q, t)
 }
@@ -1,7 +1,7 @@
 package lshensemble
 
 import (
-	"errors"
+	"context"
 	"fmt"
 	"sync"
 	"time"
@@ -10,8 +10,13 @@ import (
 )
 
 type param struct {
-	k int
-	l int
+	k  int
+	l  int
+	fp float64
+	fn float64
+	// pruned is true if the partition cannot have any domain
+	// meeting the containment threshold, so it is not searched.
+	pruned bool
 }
 
 // Partition represents a domain size partition in the LSH Ensemble index.
@@ -20,132 +25,460 @@ type Partition struct {
 	Upper int `json:"upper"`
 }
 
-// Lsh interface is implemented by LshForst and LshForestArray.
-type Lsh interface {
+// Lsh interface is implemented by LshForst, LshForestArray and BandedLsh, and
+// their memory-mapped counterparts MappedLshForest and MappedLshForestArray.
+type Lsh[K comparable] interface {
 	// Add addes a new key into the index, it won't be searchable
 	// until the next time Index() is called since the add.
-	Add(key interface{}, sig []uint64)
+	Add(key K, sig []uint64)
+	// Remove removes a key from the index, it stops appearing
+	// in query results immediately.
+	Remove(key K)
+	// Update replaces the signature of a key, the new signature
+	// won't be searchable until the next time Index() is called.
+	Update(key K, sig []uint64)
 	// Index makes all keys added so far searchable.
 	Index()
 	// Query searches the index given a minhash signature, and
 	// the LSH parameters k and l. Result keys will be written to
 	// the channel out.
 	// Closing channel done will cancels the query execution.
-	Query(sig []uint64, k, l int, out chan<- interface{}, done <-chan struct{})
+	Query(sig []uint64, k, l int, out chan<- K, done <-chan struct{})
 	// OptimalKL computes the optimal LSH parameters k and l given
 	// x, the index domain size, q, the query domain size, and t,
 	// the containment threshold. The resulting false positive (fp)
 	// and false negative (fn) probabilities are returned as well.
 	OptimalKL(x, q int, t float64) (optK, optL int, fp, fn float64)
+	// NumKeys returns the number of searchable keys.
+	NumKeys() int
+}
+
+// readOnlyLsh is implemented by the Lsh whose keys cannot be changed,
+// their Add, Remove and Update have no effect.
+type readOnlyLsh interface {
+	readOnly()
 }
 
 // LshEnsemble represents an LSH Ensemble index.
-type LshEnsemble struct {
+// It is safe to add, remove and index domains while serving queries from
+// other goroutines, queries see the domains indexed when they start.
+type LshEnsemble[K comparable] struct {
+	// Partitions are replaced rather than modified in place when
+	// domains are routed out of their ranges.
 	Partitions []Partition
-	lshes      []Lsh
+	lshes      []Lsh[K]
 	maxK       int
 	numHash    int
 	paramCache cmap.ConcurrentMap
+	// layoutMu guards Partitions and lshes.
+	layoutMu sync.RWMutex
+	// writeMu is held for reading by the operations changing the domains,
+	// and for writing while the partitions are rebalanced.
+	writeMu sync.RWMutex
+	// newLsh creates the Lsh of a new partition with the given upper
+	// bound, nil if partitions cannot be added.
+	newLsh func(upper int) Lsh[K]
+	// routing is the policy for domain sizes out of the partitions, and
+	// overflow is true if the last partition is an overflow partition.
+	routing  RoutingPolicy
+	overflow bool
+	// prefixDirKs are the numbers of hash values per band with prefix
+	// directories in new partitions.
+	prefixDirKs []int
+	// readOnly is true if any partition has a read-only Lsh, such as a
+	// memory-mapped one, in which case the domains cannot be changed.
+	readOnly bool
+	// closer releases the memory-mapped file, if any.
+	closer func() error
+	// domains keeps the signatures and sizes of the domains,
+	// nil unless the KeepDomains option is used.
+	domains *domainStore[K]
+	// sizeError is the relative standard error of estimated domain
+	// sizes, 0 if the sizes are exact.
+	sizeError float64
+	// sigInfo describes the accepted encoded signatures, nil until
+	// it is configured or the first encoded signature is added.
+	sigInfo   *SignatureInfo
+	sigInfoMu sync.Mutex
+	// normalizer is the normalization of the domain values, nil if
+	// it is not configured.
+	normalizer *Normalizer
 }
 
-// NewLshEnsemble initializes a new index consists of MinHash LSH implemented using LshForest.
-// numHash is the number of hash functions in MinHash.
-// maxK is the maximum value for the MinHash parameter K - the number of hash functions per "band".
-// initSize is the initial size of underlying hash tables to allocate.
-func NewLshEnsemble(parts []Partition, numHash, maxK, initSize int) *LshEnsemble {
-	lshes := make([]Lsh, len(parts))
-	for i := range lshes {
-		lshes[i] = NewLshForest(maxK, numHash/maxK, initSize)
+// Option configures an LshEnsemble when it is created.
+type Option func(*options)
+
+// options holds the configuration set by Options.
+type options struct {
+	keepDomains bool
+	// hashValueBits is the number of bits of b-bit MinHash hash values,
+	// 0 for the default hash value size.
+	hashValueBits int
+	sizeError     float64
+	sigInfo       *SignatureInfo
+	normalizer    *Normalizer
+	routing       RoutingPolicy
+}
+
+func newOptions(opts []Option) options {
+	var o options
+	for _, opt := range opts {
+		opt(&o)
 	}
-	return &LshEnsemble{
+	return o
+}
+
+func newLshEnsemble[K comparable](parts []Partition, lshes []Lsh[K], newLsh func(int) Lsh[K], numHash, maxK int, o options) *LshEnsemble[K] {
+	e := &LshEnsemble[K]{
 		lshes:      lshes,
 		Partitions: parts,
 		maxK:       maxK,
 		numHash:    numHash,
 		paramCache: cmap.New(),
+		newLsh:     newLsh,
+		routing:    o.routing,
+		sizeError:  o.sizeError,
+		normalizer: o.normalizer,
+	}
+	if o.sigInfo != nil {
+		info := *o.sigInfo
+		if info.NumHash == 0 {
+			info.NumHash = numHash
+		}
+		e.sigInfo = &info
+	}
+	for _, lsh := range lshes {
+		if _, ok := lsh.(readOnlyLsh); ok {
+			e.readOnly = true
+		}
+	}
+	if o.keepDomains {
+		e.domains = &domainStore[K]{
+			domains:       make(map[K]storedDomain),
+			hashValueBits: o.hashValueBits,
+		}
+	}
+	return e
+}
+
+// NewLshEnsemble initializes a new index consists of MinHash LSH implemented using LshForest.
+// numHash is the number of hash functions in MinHash.
+// maxK is the maximum value for the MinHash parameter K - the number of hash functions per "band".
+// initSize is the initial size of underlying hash tables to allocate.
+func NewLshEnsemble[K comparable](parts []Partition, numHash, maxK, initSize int, opts ...Option) *LshEnsemble[K] {
+	o := newOptions(opts)
+	newLsh := func(initSize int) Lsh[K] {
+		if o.hashValueBits > 0 {
+			return NewLshForestBBit[K](o.hashValueBits, maxK, numHash/maxK, initSize)
+		}
+		return NewLshForest[K](maxK, numHash/maxK, initSize)
+	}
+	lshes := make([]Lsh[K], len(parts))
+	for i := range lshes {
+		lshes[i] = newLsh(initSize)
 	}
+	return newLshEnsemble(parts, lshes, func(int) Lsh[K] { return newLsh(0) }, numHash, maxK, o)
 }
 
 // NewLshEnsemblePlus initializes a new index consists of MinHash LSH implemented using LshForestArray.
 // numHash is the number of hash functions in MinHash.
 // maxK is the maximum value for the MinHash parameter K - the number of hash functions per "band".
 // initSize is the initial size of underlying hash tables to allocate.
-func NewLshEnsemblePlus(parts []Partition, numHash, maxK, initSize int) *LshEnsemble {
-	lshes := make([]Lsh, len(parts))
+func NewLshEnsemblePlus[K comparable](parts []Partition, numHash, maxK, initSize int, opts ...Option) *LshEnsemble[K] {
+	o := newOptions(opts)
+	hashValueBits := o.hashValueBits
+	if hashValueBits == 0 {
+		hashValueBits = 32
+	}
+	lshes := make([]Lsh[K], len(parts))
 	for i := range lshes {
-		lshes[i] = NewLshForestArray(maxK, numHash, initSize)
+		lshes[i] = newLshForestArray[K](maxK, numHash, hashValueBits, initSize)
 	}
-	return &LshEnsemble{
-		lshes:      lshes,
-		Partitions: parts,
-		maxK:       maxK,
-		numHash:    numHash,
-		paramCache: cmap.New(),
+	newLsh := func(int) Lsh[K] {
+		return newLshForestArray[K](maxK, numHash, hashValueBits, 0)
 	}
+	return newLshEnsemble(parts, lshes, newLsh, numHash, maxK, o)
+}
+
+// NewLshEnsembleBanded initializes a new index consists of MinHash LSH implemented using BandedLsh,
+// for queries with containment thresholds no less than minThreshold.
+// The K and L of every partition are fixed to the optimal ones for minThreshold,
+// assuming the query domain size is the upper bound of the partition.
+// Queries with higher thresholds use fewer bands.
+// numHash is the number of hash functions in MinHash.
+// maxK is the maximum value for the MinHash parameter K - the number of hash functions per "band".
+// initSize is the initial number of buckets of every band to allocate.
+// An index using BandedLsh cannot be saved.
+func NewLshEnsembleBanded[K comparable](parts []Partition, numHash, maxK int, minThreshold float64, initSize int, opts ...Option) *LshEnsemble[K] {
+	o := newOptions(opts)
+	hashValueBits := o.hashValueBits
+	if hashValueBits == 0 {
+		hashValueBits = 32
+	}
+	newLsh := func(upper, initSize int) Lsh[K] {
+		k, l, _, _ := optimalKL(maxK, numHash, numHash, hashValueBits, upper, upper, minThreshold)
+		return newBandedLsh[K](k, l, hashValueBits, initSize)
+	}
+	lshes := make([]Lsh[K], len(parts))
+	for i, p := range parts {
+		lshes[i] = newLsh(p.Upper, initSize)
+	}
+	return newLshEnsemble(parts, lshes, func(upper int) Lsh[K] { return newLsh(upper, 0) },
+		numHash, maxK, o)
 }
 
 // Add a new domain to the index given its partition ID - the index of the partition.
 // The added domain won't be searchable until the Index() function is called.
-func (e *LshEnsemble) Add(key interface{}, sig []uint64, partInd int) {
-	e.lshes[partInd].Add(key, sig)
+// If the index keeps domains, the domain size is unknown, so the domain is
+// neither ranked by QueryTopK nor counted by PlanRebalance, use Prepare to
+// keep the exact size.
+// An error is returned if the signature does not match the index, or the
+// index is read-only.
+func (e *LshEnsemble[K]) Add(key K, sig []uint64, partInd int) error {
+	if e.readOnly {
+		return errReadOnly
+	}
+	if err := e.checkSignature(sig); err != nil {
+		return err
+	}
+	e.writeMu.RLock()
+	defer e.writeMu.RUnlock()
+	e.add(key, sig, -1, partInd)
+	return nil
+}
+
+// add adds the domain to the partition, size is negative if the domain
+// size is unknown.
+func (e *LshEnsemble[K]) add(key K, sig []uint64, size, partInd int) {
+	parts, lshes := e.layout()
+	lshes[partInd].Add(key, sig)
+	if e.domains != nil {
+		if size < 0 {
+			e.domains.put(key, sig, parts[partInd].Upper, false)
+		} else {
+			e.domains.put(key, sig, size, true)
+		}
+	}
+}
+
+// layout returns the partitions and their Lsh, which are consistent
+// with each other.
+func (e *LshEnsemble[K]) layout() ([]Partition, []Lsh[K]) {
+	e.layoutMu.RLock()
+	defer e.layoutMu.RUnlock()
+	return e.Partitions, e.lshes
+}
+
+// EnablePrefixDirectory makes the LshForest and LshForestArray of every
+// partition keep directories of the hash key prefixes of the given numbers
+// of hash values per band, trading memory for faster bucket look-ups,
+// see LshForest.EnablePrefixDirectory. Other Lsh implementations are not
+// affected. Calling it without any number removes the directories.
+func (e *LshEnsemble[K]) EnablePrefixDirectory(ks ...int) {
+	e.layoutMu.Lock()
+	e.prefixDirKs = ks
+	lshes := e.lshes
+	e.layoutMu.Unlock()
+	for _, lsh := range lshes {
+		enablePrefixDirectory(lsh, ks)
+	}
+}
+
+// enablePrefixDirectory enables the prefix directories of the Lsh if it
+// supports them.
+func enablePrefixDirectory[K comparable](lsh Lsh[K], ks []int) {
+	switch lsh := lsh.(type) {
+	case *LshForest[K]:
+		lsh.EnablePrefixDirectory(ks...)
+	case *LshForestArray[K]:
+		lsh.EnablePrefixDirectory(ks...)
+	}
 }
 
 // Prepare adds a new domain to the index given its size, and partition will
 // be selected automatically. It could be more efficient to use Add().
 // The added domain won't be searchable until the Index() function is called.
-func (e *LshEnsemble) Prepare(key interface{}, sig []uint64, size int) error {
-	for i := range e.Partitions {
-		if size >= e.Partitions[i].Lower && size <= e.Partitions[i].Upper {
-			e.Add(key, sig, i)
-			break
-		}
+// Sizes out of the range of the partitions are routed according to the
+// routing policy of the index, see Routing.
+// An error is returned if the index is read-only.
+func (e *LshEnsemble[K]) Prepare(key K, sig []uint64, size int) error {
+	if e.readOnly {
+		return errReadOnly
 	}
-	return errors.New("No matching partition found")
+	if err := e.checkSignature(sig); err != nil {
+		return err
+	}
+	e.writeMu.RLock()
+	defer e.writeMu.RUnlock()
+	partInd, err := e.route(size)
+	if err != nil {
+		return err
+	}
+	e.add(key, sig, size, partInd)
+	return nil
+}
+
+// Remove a domain from the index given its key.
+// The domain stops appearing in query results immediately.
+// An error is returned if the index is read-only.
+func (e *LshEnsemble[K]) Remove(key K) error {
+	if e.readOnly {
+		return errReadOnly
+	}
+	e.writeMu.RLock()
+	defer e.writeMu.RUnlock()
+	e.remove(key)
+	return nil
+}
+
+func (e *LshEnsemble[K]) remove(key K) {
+	_, lshes := e.layout()
+	for i := range lshes {
+		lshes[i].Remove(key)
+	}
+	if e.domains != nil {
+		e.domains.remove(key)
+	}
+}
+
+// Update replaces the signature and size of a domain, the domain may be
+// moved to another partition due to its new size.
+// The domain won't be searchable until the Index() function is called.
+// An error is returned if the index is read-only.
+func (e *LshEnsemble[K]) Update(key K, sig []uint64, size int) error {
+	if e.readOnly {
+		return errReadOnly
+	}
+	if err := e.checkSignature(sig); err != nil {
+		return err
+	}
+	e.writeMu.RLock()
+	defer e.writeMu.RUnlock()
+	partInd, err := e.route(size)
+	if err != nil {
+		return err
+	}
+	e.remove(key)
+	e.add(key, sig, size, partInd)
+	return nil
 }
 
 // Index makes all added domains searchable.
-func (e *LshEnsemble) Index() {
-	for i := range e.lshes {
-		e.lshes[i].Index()
+func (e *LshEnsemble[K]) Index() {
+	e.writeMu.RLock()
+	defer e.writeMu.RUnlock()
+	_, lshes := e.layout()
+	for i := range lshes {
+		lshes[i].Index()
 	}
 }
 
+// IndexParallel is similar to Index, but indexes the partitions in
+// parallel using numWorkers goroutines.
+func (e *LshEnsemble[K]) IndexParallel(numWorkers int) {
+	if numWorkers < 1 {
+		numWorkers = 1
+	}
+	e.writeMu.RLock()
+	defer e.writeMu.RUnlock()
+	parts := make(chan Lsh[K])
+	var wg sync.WaitGroup
+	wg.Add(numWorkers)
+	for w := 0; w < numWorkers; w++ {
+		go func() {
+			for lsh := range parts {
+				lsh.Index()
+			}
+			wg.Done()
+		}()
+	}
+	_, lshes := e.layout()
+	for i := range lshes {
+		parts <- lshes[i]
+	}
+	close(parts)
+	wg.Wait()
+}
+
+// Close releases the memory-mapped file of an index opened using
+// OpenLshEnsemble. It is a no-op for in-memory indexes.
+// The index must not be used after it is closed.
+func (e *LshEnsemble[K]) Close() error {
+	if e.closer == nil {
+		return nil
+	}
+	err := e.closer()
+	e.closer = nil
+	return err
+}
+
 // Query returns the candidate domain keys in a channel.
 // This function is given the MinHash signature of the query domain, sig, the domain size,
 // the containment threshold, and a cancellation channel.
 // Closing channel done will cancel the query execution.
 // The query signature must be generated using the same seed as the signatures of the indexed domains,
-// and have the same number of hash functions.
-func (e *LshEnsemble) Query(sig []uint64, size int, threshold float64, done <-chan struct{}) <-chan interface{} {
-	params := e.computeParams(size, threshold)
-	return e.queryWithParam(sig, params, done)
+// and have the same number of hash functions, otherwise an error is returned.
+func (e *LshEnsemble[K]) Query(sig []uint64, size int, threshold float64, done <-chan struct{}) (<-chan K, error) {
+	if err := e.checkSignature(sig); err != nil {
+		return nil, err
+	}
+	parts, lshes := e.layout()
+	params := e.computeParams(parts, lshes, size, threshold)
+	return e.queryWithParam(sig, lshes, params, done), nil
 }
 
 // QueryTimed is similar to Query, returns the candidate domain keys in a slice as well as the running time.
-func (e *LshEnsemble) QueryTimed(sig []uint64, size int, threshold float64) (result []interface{}, dur time.Duration) {
+// An error is returned if the signature does not match the index.
+func (e *LshEnsemble[K]) QueryTimed(sig []uint64, size int, threshold float64) (result []K, dur time.Duration, err error) {
+	if err := e.checkSignature(sig); err != nil {
+		return nil, 0, err
+	}
 	// Compute the optimal k and l for each partition
-	params := e.computeParams(size, threshold)
-	result = make([]interface{}, 0)
+	parts, lshes := e.layout()
+	params := e.computeParams(parts, lshes, size, threshold)
+	result = make([]K, 0)
 	done := make(chan struct{})
 	defer close(done)
 	start := time.Now()
-	for key := range e.queryWithParam(sig, params, done) {
+	for key := range e.queryWithParam(sig, lshes, params, done) {
 		result = append(result, key)
 	}
 	dur = time.Since(start)
-	return result, dur
+	return result, dur, nil
+}
+
+// QueryContext is similar to QueryTimed, returns the candidate domain keys in a slice.
+// The query execution is cancelled when ctx is done, in which case the
+// candidates found so far are returned together with the context's error.
+func (e *LshEnsemble[K]) QueryContext(ctx context.Context, sig []uint64, size int, threshold float64) ([]K, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if err := e.checkSignature(sig); err != nil {
+		return nil, err
+	}
+	parts, lshes := e.layout()
+	params := e.computeParams(parts, lshes, size, threshold)
+	result := make([]K, 0)
+	for key := range e.queryWithParam(sig, lshes, params, ctx.Done()) {
+		result = append(result, key)
+	}
+	return result, ctx.Err()
 }
 
-func (e *LshEnsemble) queryWithParam(sig []uint64, params []param, done <-chan struct{}) <-chan interface{} {
+func (e *LshEnsemble[K]) queryWithParam(sig []uint64, lshes []Lsh[K], params []param, done <-chan struct{}) <-chan K {
 	// Collect candidates from all partitions
-	keyChan := make(chan interface{})
+	keyChan := make(chan K)
 	var wg sync.WaitGroup
-	wg.Add(len(e.lshes))
-	for i := range e.lshes {
-		go func(lsh Lsh, k, l int) {
+	for i := range lshes {
+		if params[i].pruned {
+			continue
+		}
+		wg.Add(1)
+		go func(lsh Lsh[K], k, l int) {
 			lsh.Query(sig, k, l, keyChan, done)
 			wg.Done()
-		}(e.lshes[i], params[i].k, params[i].l)
+		}(lshes[i], params[i].k, params[i].l)
 	}
 	go func() {
 		wg.Wait()
@@ -154,17 +487,22 @@ func (e *LshEnsemble) queryWithParam(sig []uint64, params []param, done <-chan s
 	return keyChan
 }
 
-// Compute the optimal k and l for each partition
-func (e *LshEnsemble) computeParams(size int, threshold float64) []param {
-	params := make([]param, len(e.Partitions))
-	for i, p := range e.Partitions {
-		x := p.Upper
-		key := cacheKey(x, size, threshold)
+// Compute the optimal k and l for each partition,
+// partitions that cannot meet the containment threshold are pruned.
+func (e *LshEnsemble[K]) computeParams(parts []Partition, lshes []Lsh[K], size int, threshold float64) []param {
+	params := make([]param, len(parts))
+	for i, p := range parts {
+		x, q := e.widenSizes(p.Upper, size)
+		if !canMatch(x, q, threshold) {
+			params[i] = param{pruned: true}
+			continue
+		}
+		key := cacheKey(i, x, q, threshold)
 		if cached, exist := e.paramCache.Get(key); exist {
 			params[i] = cached.(param)
 		} else {
-			optK, optL, _, _ := e.lshes[i].OptimalKL(x, size, threshold)
-			computed := param{optK, optL}
+			optK, optL, fp, fn := lshes[i].OptimalKL(x, q, threshold)
+			computed := param{k: optK, l: optL, fp: fp, fn: fn}
 			e.paramCache.Set(key, computed)
 			params[i] = computed
 		}
@@ -172,7 +510,24 @@ func (e *LshEnsemble) computeParams(size int, threshold float64) []param {
 	return params
 }
 
-// Make a cache key with threshold precision to 2 decimal points
-func cacheKey(x, q int, t float64) string {
-	return fmt.Sprintf("%.8x %.8x %.2f", x, q, t)
+// NumPruned returns the number of partitions that are skipped by a query
+// with the given size and containment threshold, because their domains are
+// too small to meet the threshold.
+func (e *LshEnsemble[K]) NumPruned(size int, threshold float64) int {
+	var n int
+	parts, _ := e.layout()
+	for _, p := range parts {
+		x, q := e.widenSizes(p.Upper, size)
+		if !canMatch(x, q, threshold) {
+			n++
+		}
+	}
+	return n
+}
+
+// Make a cache key with threshold precision to 2 decimal points,
+// the partition is part of the key as the Lsh of partitions may have
+// different parameters.
+func cacheKey(part, x, q int, t float64) string {
+	return fmt.Sprintf("%d %.8x %.8x %.2f", part, x, q, t)
 }
@@ -41,11 +41,11 @@ func benchmarkLshEnsemble(rawDomains []rawDomain, rawQueries []rawDomain,
 	log.Print("Start building LSH Ensemble index")
 	mem = readMemStats()
 	start = time.Now()
-	sort.Sort(BySize(domainRecords))
-	var index *LshEnsemble
+	sort.Sort(BySize[string](domainRecords))
+	var index *LshEnsemble[string]
 	if useOptimalPartitions {
 		index, _ = BootstrapLshEnsemblePlusOptimal(numPart, numHash, maxK,
-			func() <-chan *DomainRecord { return Recs2Chan(domainRecords) })
+			func() <-chan *DomainRecord[string] { return Recs2Chan(domainRecords) })
 	} else {
 		index, _ = BootstrapLshEnsemblePlusEquiDepth(numPart, numHash, maxK,
 			len(domainRecords), Recs2Chan(domainRecords))
@@ -60,7 +60,10 @@ func benchmarkLshEnsemble(rawDomains []rawDomain, rawQueries []rawDomain,
 	start = time.Now()
 	go func() {
 		for _, query := range queries {
-			r, d := index.QueryTimed(query.Signature, query.Size, threshold)
+			r, d, err := index.QueryTimed(query.Signature, query.Size, threshold)
+			if err != nil {
+				log.Fatal(err)
+			}
 			results <- queryResult{
 				queryKey:   query.Key,
 				duration:   d,
@@ -87,14 +90,14 @@ func benchmarkLshEnsemble(rawDomains []rawDomain, rawQueries []rawDomain,
 	return []float64{float64(minHashDomainTime), float64(minHashQueryTime), float64(buildIndexTime), float64(queryIndexTime)}, []float64{float64(minHashDomainSpace), float64(minHashQuerySpace), float64(buildIndexSpace), float64(queryIndexSpace)}
 }
 
-func minhashDomains(rawDomains []rawDomain, numHash int) []*DomainRecord {
-	domainRecords := make([]*DomainRecord, 0)
+func minhashDomains(rawDomains []rawDomain, numHash int) []*DomainRecord[string] {
+	domainRecords := make([]*DomainRecord[string], 0)
 	for _, domain := range rawDomains {
 		mh := NewMinhash(benchmarkSeed, numHash)
 		for v := range domain.values {
 			mh.Push([]byte(v))
 		}
-		domainRecords = append(domainRecords, &DomainRecord{
+		domainRecords = append(domainRecords, &DomainRecord[string]{
 			Key:       domain.key,
 			Size:      len(domain.values),
 			Signature: mh.Signature(),
@@ -1,7 +1,10 @@
 package lshensemble
 
 import (
+	"bytes"
+	"context"
 	"sort"
+	"sync"
 	"testing"
 )
 
@@ -18,19 +21,19 @@ func Test_LshEnsembleEquiDepth(t *testing.T) {
 		"3",
 		"4",
 	}
-	domainRecords := make([]*DomainRecord, 0)
+	domainRecords := make([]*DomainRecord[string], 0)
 	for i := range domains {
 		mh := NewMinhash(1, 128)
 		for _, v := range domains[i] {
 			mh.Push([]byte(v))
 		}
-		domainRecords = append(domainRecords, &DomainRecord{
+		domainRecords = append(domainRecords, &DomainRecord[string]{
 			Key:       keys[i],
 			Size:      len(domains[i]),
 			Signature: mh.Signature(),
 		})
 	}
-	sort.Sort(BySize(domainRecords))
+	sort.Sort(BySize[string](domainRecords))
 	index, err := BootstrapLshEnsembleEquiDepth(4, 128, 4, len(domainRecords),
 		Recs2Chan(domainRecords))
 	if err != nil {
@@ -43,7 +46,11 @@ func Test_LshEnsembleEquiDepth(t *testing.T) {
 	var found bool
 	done := make(chan struct{})
 	defer close(done)
-	for key := range index.Query(querySig, querySize, threshold, done) {
+	results, err := index.Query(querySig, querySize, threshold, done)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for key := range results {
 		if key == "1" {
 			found = true
 			break
@@ -67,21 +74,21 @@ func Test_LshEnsembleOptimal(t *testing.T) {
 		"3",
 		"4",
 	}
-	domainRecords := make([]*DomainRecord, 0)
+	domainRecords := make([]*DomainRecord[string], 0)
 	for i := range domains {
 		mh := NewMinhash(1, 128)
 		for _, v := range domains[i] {
 			mh.Push([]byte(v))
 		}
-		domainRecords = append(domainRecords, &DomainRecord{
+		domainRecords = append(domainRecords, &DomainRecord[string]{
 			Key:       keys[i],
 			Size:      len(domains[i]),
 			Signature: mh.Signature(),
 		})
 	}
-	sort.Sort(BySize(domainRecords))
+	sort.Sort(BySize[string](domainRecords))
 	index, err := BootstrapLshEnsembleOptimal(4, 128, 4,
-		func() <-chan *DomainRecord { return Recs2Chan(domainRecords) })
+		func() <-chan *DomainRecord[string] { return Recs2Chan(domainRecords) })
 	if err != nil {
 		t.Error(err)
 	}
@@ -92,7 +99,11 @@ func Test_LshEnsembleOptimal(t *testing.T) {
 	var found bool
 	done := make(chan struct{})
 	defer close(done)
-	for key := range index.Query(querySig, querySize, threshold, done) {
+	results, err := index.Query(querySig, querySize, threshold, done)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for key := range results {
 		if key == "1" {
 			found = true
 			break
@@ -102,3 +113,180 @@ func Test_LshEnsembleOptimal(t *testing.T) {
 		t.Fatal("unable to retrieve inserted key")
 	}
 }
+
+func Test_LshEnsembleRemoveUpdate(t *testing.T) {
+	recs := testDomainRecords(100, 64)
+	index, err := BootstrapLshEnsembleEquiDepth(4, 64, 4, len(recs), Recs2Chan(recs))
+	if err != nil {
+		t.Fatal(err)
+	}
+	contains := func(sig []uint64, size int, key string) bool {
+		result, _, err := index.QueryTimed(sig, size, 0.9)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, k := range result {
+			if k == key {
+				return true
+			}
+		}
+		return false
+	}
+	removed, updated := recs[0], recs[1]
+	if err := index.Remove(removed.Key); err != nil {
+		t.Fatal(err)
+	}
+	if contains(removed.Signature, removed.Size, removed.Key) {
+		t.Fatal("Removed domain was returned")
+	}
+	// Only the partition of the domain is affected.
+	for i, lsh := range index.lshes {
+		expected := 0
+		if i == 0 {
+			expected = 1
+		}
+		if n := lsh.(*LshForest[string]).tombstones.len(); n != expected {
+			t.Fatalf("Partition %d has %d tombstones, expecting %d", i, n, expected)
+		}
+	}
+	last := recs[len(recs)-1]
+	if err := index.Update(updated.Key, last.Signature, last.Size); err != nil {
+		t.Fatal(err)
+	}
+	index.Index()
+	if !contains(last.Signature, last.Size, updated.Key) {
+		t.Fatal("Unable to retrieve updated domain")
+	}
+	if contains(updated.Signature, updated.Size, updated.Key) {
+		t.Fatal("Updated domain was returned for its old signature")
+	}
+}
+
+func Test_LshEnsembleQueryContext(t *testing.T) {
+	recs := testDomainRecords(100, 64)
+	index, err := BootstrapLshEnsembleEquiDepth(4, 64, 4, len(recs), Recs2Chan(recs))
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, err := index.QueryContext(context.Background(), recs[0].Signature, recs[0].Size, 0.9)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var found bool
+	for _, key := range result {
+		if key == recs[0].Key {
+			found = true
+		}
+	}
+	if !found {
+		t.Fatal("unable to retrieve inserted key")
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := index.QueryContext(ctx, recs[0].Signature, recs[0].Size, 0.9); err != context.Canceled {
+		t.Fatalf("Expected context.Canceled, got %v", err)
+	}
+}
+
+func Test_LshEnsembleConcurrentAdd(t *testing.T) {
+	recs := testDomainRecords(400, 64)
+	parts := []Partition{{Lower: 1, Upper: 10}, {Lower: 11, Upper: 20}}
+	for _, index := range []*LshEnsemble[string]{
+		NewLshEnsemble[string](parts, 64, 4, 0),
+		NewLshEnsemblePlus[string](parts, 64, 4, 0),
+	} {
+		index.EnablePrefixDirectory(2, 4)
+		var writers, readers sync.WaitGroup
+		stop := make(chan struct{})
+		// Readers query continuously until the writers are done.
+		for r := 0; r < 4; r++ {
+			readers.Add(1)
+			go func(r int) {
+				defer readers.Done()
+				for i := r; ; i = (i + 1) % len(recs) {
+					select {
+					case <-stop:
+						return
+					default:
+					}
+					if _, err := index.QueryContext(context.Background(),
+						recs[i].Signature, recs[i].Size, 0.8); err != nil {
+						t.Error(err)
+						return
+					}
+				}
+			}(r)
+		}
+		for w := 0; w < 2; w++ {
+			writers.Add(1)
+			go func(w int) {
+				defer writers.Done()
+				for i := w; i < len(recs); i += 2 {
+					part := 0
+					if recs[i].Size > parts[0].Upper {
+						part = 1
+					}
+					if err := index.Add(recs[i].Key, recs[i].Signature, part); err != nil {
+						t.Error(err)
+						return
+					}
+					if i%10 < 2 {
+						index.Index()
+					}
+				}
+			}(w)
+		}
+		writers.Wait()
+		close(stop)
+		readers.Wait()
+		index.Index()
+		for _, rec := range recs {
+			result, err := index.QueryContext(context.Background(), rec.Signature, rec.Size, 0.8)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var found bool
+			for _, key := range result {
+				found = found || key == rec.Key
+			}
+			if !found {
+				t.Fatalf("Unable to retrieve key %s", rec.Key)
+			}
+		}
+	}
+}
+
+func Test_LshEnsembleIntKeys(t *testing.T) {
+	strRecs := testDomainRecords(100, 64)
+	recs := make([]*DomainRecord[int], len(strRecs))
+	for i, rec := range strRecs {
+		recs[i] = &DomainRecord[int]{Key: i, Size: rec.Size, Signature: rec.Signature}
+	}
+	index, err := BootstrapLshEnsembleEquiDepth(4, 64, 4, len(recs), Recs2Chan(recs))
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, _, err := index.QueryTimed(recs[10].Signature, recs[10].Size, 0.9)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var found bool
+	for _, key := range result {
+		if key == 10 {
+			found = true
+		}
+	}
+	if !found {
+		t.Fatal("unable to retrieve inserted key")
+	}
+	var buf bytes.Buffer
+	if _, err := index.WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ReadLshEnsemble[string](bytes.NewReader(buf.Bytes())); err == nil {
+		t.Fatal("Expected error reading int keys as string keys")
+	}
+	if _, err := ReadLshEnsemble[int](bytes.NewReader(buf.Bytes())); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -1,164 +1,745 @@
 package lshensemble
 
 import (
+	"bytes"
+	"fmt"
+	"iter"
+	"maps"
 	"math"
 	"sort"
+	"sync"
+	"sync/atomic"
 )
 
 const (
 	integrationPrecision = 0.01
+	// compactionRatio is the fraction of removed keys over all keys
+	// in an LshForest that triggers a background compaction.
+	compactionRatio = 0.1
+	// maxForestKeys is the largest number of keys in the key dictionary
+	// of an LshForest, the largest id is reserved by compactKeys.
+	maxForestKeys = math.MaxUint32
 )
 
 // NewLshForest default constructor uses 32 bit hash value
-var NewLshForest = NewLshForest32
+func NewLshForest[K comparable](k, l, initSize int) *LshForest[K] {
+	return NewLshForest32[K](k, l, initSize)
+}
+
+// hashTable is a look-up table stored in columns: the fixed-width hash keys
+// of all entries packed in a byte slice, and the ids of their keys in the
+// key dictionary of the LshForest. The entries are sorted by hash keys,
+// and look-up operation is implemented using binary search.
+type hashTable struct {
+	width    int
+	hashKeys []byte
+	ids      []uint32
+}
+
+func newHashTable(width, initSize int) hashTable {
+	return hashTable{
+		width:    width,
+		hashKeys: make([]byte, 0, width*initSize),
+		ids:      make([]uint32, 0, initSize),
+	}
+}
+
+func (h hashTable) Len() int { return len(h.ids) }
 
-// entry contains the hash key (from minhash signature) and the indexed key
-type entry struct {
-	hashKey string
-	key     interface{}
+func (h hashTable) Less(i, j int) bool {
+	return bytes.Compare(h.hashKey(i), h.hashKey(j)) < 0
 }
 
-// hashTable is a look-up table implemented as a slice sorted by hash keys.
-// Look-up operation is implemented using binary search.
-type hashTable []entry
+func (h hashTable) Swap(i, j int) {
+	h.ids[i], h.ids[j] = h.ids[j], h.ids[i]
+	a, b := h.hashKey(i), h.hashKey(j)
+	for x := range a {
+		a[x], b[x] = b[x], a[x]
+	}
+}
 
-func (h hashTable) Len() int           { return len(h) }
-func (h hashTable) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
-func (h hashTable) Less(i, j int) bool { return h[i].hashKey < h[j].hashKey }
+// hashKey returns the hash key of the i-th entry.
+func (h hashTable) hashKey(i int) []byte {
+	return h.hashKeys[i*h.width : (i+1)*h.width]
+}
+
+// slice returns the entries from start to end, sharing the storage.
+func (h hashTable) slice(start, end int) hashTable {
+	return hashTable{h.width, h.hashKeys[start*h.width : end*h.width], h.ids[start:end]}
+}
+
+// append adds an entry to the end of the table.
+func (h *hashTable) append(hashKey []byte, id uint32) {
+	h.hashKeys = append(h.hashKeys, hashKey...)
+	h.ids = append(h.ids, id)
+}
 
 // LshForest represents a MinHash LSH implemented using LSH Forest
 // (http://ilpubs.stanford.edu:8090/678/1/2005-14.pdf).
 // It supports query-time setting of the MinHash LSH parameters
 // L (number of bands) and
 // K (number of hash functions per band).
-type LshForest struct {
+type LshForest[K comparable] struct {
 	k              int
 	l              int
 	hashTables     []hashTable
 	hashKeyFunc    hashKeyFunc
-	hashValueSize  int
+	hashValueBits  int
 	numIndexedKeys int
+	// keys is the key dictionary, the entries of the hash tables refer
+	// to keys by their positions in it, and keyIDs maps keys to their
+	// positions. Removed keys stay in the dictionary until it is
+	// compacted by Index(), but leave keyIDs once their entries are
+	// compacted.
+	keys   []K
+	keyIDs map[K]uint32
+	// mu guards the hash tables and the removal state below. Only writers
+	// hold it, queries read the snapshot.
+	mu sync.RWMutex
+	// tombstones are the removed keys whose entries are not yet compacted.
+	tombstones *tombstoneSet[K]
+	// pending are the entries of removed keys that were added again,
+	// they are inserted after the old entries are compacted.
+	pending []pendingEntry[K]
+	// reinserting are the keys of the pending entries taken by the
+	// running rebuild.
+	reinserting map[K]bool
+	// rebuildDone is closed when the running rebuild finishes, nil if
+	// there is no rebuild running.
+	rebuildDone chan struct{}
+	// prefixDirKs are the numbers of hash values per band having prefix
+	// directories.
+	prefixDirKs []int
+	// snapshot is the view of the indexed entries read by queries.
+	snapshot atomic.Pointer[forestSnapshot[K]]
+}
+
+// forestSnapshot is an immutable view of the indexed entries of an
+// LshForest. A new snapshot is published whenever the indexed entries or
+// the removed keys change, and the storage it refers to is never modified
+// afterwards, so queries can keep reading it without locking while keys
+// are being added, removed and indexed.
+type forestSnapshot[K comparable] struct {
+	tables []hashTable
+	keys   []K
+	// prefixDirs are the prefix directories of every hash table by the
+	// number of hash values per band.
+	prefixDirs map[int][]prefixDirectory
+	// tombstones are the removed keys, whose entries may still be in the
+	// tables.
+	tombstones *tombstoneSet[K]
+}
+
+// publish replaces the snapshot with the indexed entries of the hash
+// tables and their prefix directories, the caller must hold the lock.
+func (f *LshForest[K]) publish(prefixDirs map[int][]prefixDirectory) {
+	tables := make([]hashTable, len(f.hashTables))
+	for i, ht := range f.hashTables {
+		tables[i] = ht.slice(0, f.numIndexedKeys)
+	}
+	f.snapshot.Store(&forestSnapshot[K]{tables, f.keys, prefixDirs, f.tombstones})
+}
+
+// publishTombstones replaces the snapshot with one having the current
+// removed keys, the caller must hold the lock.
+func (f *LshForest[K]) publishTombstones() {
+	s := *f.snapshot.Load()
+	s.tombstones = f.tombstones
+	f.snapshot.Store(&s)
+}
+
+// tombstoneSet is an immutable set of removed keys. Adding a key copies
+// only the recent layer of the set, which is merged into the base layer
+// once its size reaches the square root of the base layer, so adding n
+// keys one by one takes O(n sqrt(n)) time. The parent holds the keys
+// being compacted by a rebuild, and is dropped when the rebuild finishes.
+type tombstoneSet[K comparable] struct {
+	parent       *tombstoneSet[K]
+	base, recent map[K]bool
+}
+
+func (t *tombstoneSet[K]) has(key K) bool {
+	return t.base[key] || t.recent[key] || (t.parent != nil && t.parent.has(key))
+}
+
+func (t *tombstoneSet[K]) len() int {
+	n := len(t.base) + len(t.recent)
+	if t.parent != nil {
+		n += t.parent.len()
+	}
+	return n
+}
+
+// all returns the keys of the set, a key may be returned more than once.
+func (t *tombstoneSet[K]) all() iter.Seq[K] {
+	return func(yield func(K) bool) {
+		for _, m := range []map[K]bool{t.base, t.recent} {
+			for key := range m {
+				if !yield(key) {
+					return
+				}
+			}
+		}
+		if t.parent != nil {
+			for key := range t.parent.all() {
+				if !yield(key) {
+					return
+				}
+			}
+		}
+	}
+}
+
+// with returns the set with the key added. The key is added even if the
+// parent has it, so it stays in the set when the parent is dropped.
+func (t *tombstoneSet[K]) with(key K) *tombstoneSet[K] {
+	if t.base[key] || t.recent[key] {
+		return t
+	}
+	if len(t.recent)*len(t.recent) >= len(t.base) {
+		base := make(map[K]bool, len(t.base)+len(t.recent)+1)
+		maps.Copy(base, t.base)
+		maps.Copy(base, t.recent)
+		base[key] = true
+		return &tombstoneSet[K]{parent: t.parent, base: base}
+	}
+	recent := make(map[K]bool, len(t.recent)+1)
+	maps.Copy(recent, t.recent)
+	recent[key] = true
+	return &tombstoneSet[K]{parent: t.parent, base: t.base, recent: recent}
+}
+
+// detach returns the set without its parent.
+func (t *tombstoneSet[K]) detach() *tombstoneSet[K] {
+	return &tombstoneSet[K]{base: t.base, recent: t.recent}
+}
+
+// prefixRange is the range of entries sharing a hash key prefix in a
+// sorted hash table.
+type prefixRange struct {
+	start, end uint32
+}
+
+// prefixDirectory maps the hash key prefixes of a sorted hash table to
+// the ranges of entries, so looking up a bucket takes constant time.
+type prefixDirectory map[string]prefixRange
+
+// buildPrefixDirectory builds the directory of the prefixes of nbits bits
+// of the hash keys in the sorted hash table.
+func buildPrefixDirectory(ht hashTable, nbits int) prefixDirectory {
+	dir := make(prefixDirectory)
+	var buf []byte
+	for start := 0; start < ht.Len(); {
+		end := start + 1
+		for end < ht.Len() && comparePrefix(ht.hashKey(end), ht.hashKey(start), nbits) == 0 {
+			end++
+		}
+		buf = prefixKey(buf[:0], ht.hashKey(start), nbits)
+		dir[string(buf)] = prefixRange{uint32(start), uint32(end)}
+		start = end
+	}
+	return dir
+}
+
+// buildPrefixDirs builds the prefix directories of the first numIndexedKeys
+// entries of the hash tables for every k in ks.
+func buildPrefixDirs(tables []hashTable, numIndexedKeys, hashValueBits int, ks []int) map[int][]prefixDirectory {
+	if len(ks) == 0 {
+		return nil
+	}
+	dirs := make(map[int][]prefixDirectory, len(ks))
+	for _, k := range ks {
+		dirs[k] = make([]prefixDirectory, len(tables))
+		for i, ht := range tables {
+			dirs[k][i] = buildPrefixDirectory(ht.slice(0, numIndexedKeys), k*hashValueBits)
+		}
+	}
+	return dirs
 }
 
-func newLshForest(k, l, hashValueSize, initSize int) *LshForest {
+// pendingEntry holds the hash keys of a key waiting to be inserted,
+// concatenated in the order of the hash tables.
+type pendingEntry[K comparable] struct {
+	key      K
+	hashKeys []byte
+}
+
+// compactTables returns copies of the hash tables without the entries of
+// removed keys, as well as the number of entries remaining in the indexed
+// prefix of the tables.
+func compactTables[K comparable](tables []hashTable, numIndexedKeys int, keys []K, removed *tombstoneSet[K]) ([]hashTable, int) {
+	compacted := make([]hashTable, len(tables))
+	for i, ht := range tables {
+		compacted[i] = newHashTable(ht.width, ht.Len())
+		for j := 0; j < ht.Len(); j++ {
+			if !removed.has(keys[ht.ids[j]]) {
+				compacted[i].append(ht.hashKey(j), ht.ids[j])
+			}
+		}
+	}
+	// The indexed prefixes of all tables contain the same entries.
+	var numRemoved int
+	if len(tables) > 0 {
+		for j := 0; j < numIndexedKeys; j++ {
+			if removed.has(keys[tables[0].ids[j]]) {
+				numRemoved++
+			}
+		}
+	}
+	return compacted, numIndexedKeys - numRemoved
+}
+
+func newLshForest[K comparable](k, l, hashValueBits, initSize int) *LshForest[K] {
 	if k < 0 || l < 0 {
 		panic("k and l must be positive")
 	}
+	width := hashKeyWidth(k, hashValueBits)
 	hashTables := make([]hashTable, l)
 	for i := range hashTables {
-		hashTables[i] = make(hashTable, 0, initSize)
+		hashTables[i] = newHashTable(width, initSize)
 	}
-	return &LshForest{
+	f := &LshForest[K]{
 		k:              k,
 		l:              l,
-		hashValueSize:  hashValueSize,
+		hashValueBits:  hashValueBits,
 		hashTables:     hashTables,
-		hashKeyFunc:    hashKeyFuncGen(hashValueSize),
+		hashKeyFunc:    hashKeyFuncFor(hashValueBits),
 		numIndexedKeys: 0,
+		keyIDs:         make(map[K]uint32),
+		tombstones:     &tombstoneSet[K]{},
 	}
+	f.publish(nil)
+	return f
 }
 
 // NewLshForest64 uses 64-bit hash values.
-func NewLshForest64(k, l, initSize int) *LshForest {
-	return newLshForest(k, l, 8, initSize)
+func NewLshForest64[K comparable](k, l, initSize int) *LshForest[K] {
+	return newLshForest[K](k, l, 64, initSize)
 }
 
 // NewLshForest32 uses 32-bit hash values.
 // MinHash signatures with 64 bit hash values will have
 // their hash values trimed.
-func NewLshForest32(k, l, initSize int) *LshForest {
-	return newLshForest(k, l, 4, initSize)
+func NewLshForest32[K comparable](k, l, initSize int) *LshForest[K] {
+	return newLshForest[K](k, l, 32, initSize)
 }
 
 // NewLshForest16 uses 16-bit hash values.
 // MinHash signatures with 64 or 32 bit hash values will have
 // their hash values trimed.
-func NewLshForest16(k, l, initSize int) *LshForest {
-	return newLshForest(k, l, 2, initSize)
+func NewLshForest16[K comparable](k, l, initSize int) *LshForest[K] {
+	return newLshForest[K](k, l, 16, initSize)
 }
 
-func (f *LshForest) hashKeys(sig []uint64, K int) []string {
-	hs := make([]string, f.l)
+// NewLshForestBBit uses b-bit hash values, where b is between 1 and 8,
+// so the hash keys of k hash values take only k * b bits.
+// The false positive and negative probabilities take into account the
+// accidental collisions of b-bit hash values.
+func NewLshForestBBit[K comparable](b, k, l, initSize int) *LshForest[K] {
+	if b < 1 || b > 8 {
+		panic("b must be between 1 and 8")
+	}
+	return newLshForest[K](k, l, b, initSize)
+}
+
+// hashKeyFuncFor returns the hashKeyFunc for hash values of the given bits.
+func hashKeyFuncFor(hashValueBits int) hashKeyFunc {
+	if hashValueBits%8 == 0 {
+		return hashKeyFuncGen(hashValueBits / 8)
+	}
+	return packedHashKeyFuncGen(hashValueBits)
+}
+
+// hashKeys returns the hash keys of the first k hash values of every band,
+// sharing a single buffer.
+func (f *LshForest[K]) hashKeys(sig []uint64, k int) [][]byte {
+	width := hashKeyWidth(k, f.hashValueBits)
+	buf := make([]byte, 0, f.l*width)
+	hs := make([][]byte, f.l)
 	for i := 0; i < f.l; i++ {
-		hs[i] = f.hashKeyFunc(sig[i*f.k : i*f.k+K])
+		buf = f.hashKeyFunc(buf, sig[i*f.k:i*f.k+k])
+		hs[i] = buf[i*width : (i+1)*width]
 	}
 	return hs
 }
 
 // Add a key with MinHash signature into the index.
 // The key won't be searchable until Index() is called.
-func (f *LshForest) Add(key interface{}, sig []uint64) {
-	// Generate hash keys
-	hs := f.hashKeys(sig, f.k)
-	// Insert keys into the hash tables by appending.
+// Add panics if the key dictionary is full, it holds at most 2^32-1 keys,
+// including the removed keys until Index() compacts the dictionary.
+func (f *LshForest[K]) Add(key K, sig []uint64) {
+	// Generate hash keys of all bands into one buffer.
+	var hs []byte
+	for i := 0; i < f.l; i++ {
+		hs = f.hashKeyFunc(hs, sig[i*f.k:(i+1)*f.k])
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.tombstones.has(key) {
+		// The old entries of the key must be compacted first.
+		f.pending = append(f.pending, pendingEntry[K]{key, hs})
+		return
+	}
+	f.insert(key, hs)
+}
+
+// insert appends the hash keys to the hash tables, the caller must hold
+// the lock.
+func (f *LshForest[K]) insert(key K, hs []byte) {
+	id, exists := f.keyIDs[key]
+	if !exists {
+		if len(f.keys) >= maxForestKeys {
+			panic(fmt.Sprintf("LshForest cannot hold more than %d keys", maxForestKeys))
+		}
+		id = uint32(len(f.keys))
+		f.keys = append(f.keys, key)
+		f.keyIDs[key] = id
+	}
 	for i := range f.hashTables {
-		f.hashTables[i] = append(f.hashTables[i], entry{hs[i], key})
+		ht := &f.hashTables[i]
+		ht.append(hs[i*ht.width:(i+1)*ht.width], id)
+	}
+}
+
+// Remove a key from the index. The key stops appearing in query results
+// immediately, while its entries are compacted in the background once
+// enough keys have been removed, or by the next call to Index().
+// Removing a key the forest does not hold has no effect.
+func (f *LshForest[K]) Remove(key K) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	// A forest without hash tables holds no entries to remove.
+	if len(f.hashTables) == 0 {
+		return
+	}
+	// Pending entries belong to keys that are already removed.
+	pending := f.pending[:0]
+	for _, p := range f.pending {
+		if p.key != key {
+			pending = append(pending, p)
+		}
+	}
+	f.pending = pending
+	if _, exists := f.keyIDs[key]; !exists {
+		return
+	}
+	// A key being reinserted by the running rebuild must be removed
+	// again, as the tombstones of the rebuild are dropped with its entries.
+	if f.tombstones.has(key) && !f.reinserting[key] {
+		return
+	}
+	f.tombstones = f.tombstones.with(key)
+	f.publishTombstones()
+	if f.rebuildDone == nil &&
+		float64(f.tombstones.len()) > compactionRatio*float64(f.hashTables[0].Len()) {
+		f.compactInBackground()
+	}
+}
+
+// Update replaces the MinHash signature of a key.
+// The new signature won't be searchable until Index() is called, and the key
+// does not appear in query results until then.
+func (f *LshForest[K]) Update(key K, sig []uint64) {
+	f.Remove(key)
+	f.Add(key, sig)
+}
+
+// compactInBackground starts a rebuild compacting the entries of the
+// removed keys in a new goroutine, the caller must hold the lock.
+func (f *LshForest[K]) compactInBackground() {
+	f.rebuildDone = make(chan struct{})
+	go func() {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		f.rebuild(false)
+	}()
+}
+
+// rebuild compacts the entries of the removed keys and inserts the pending
+// entries, and if index is true, also makes all the entries searchable.
+// The caller must hold the lock and set rebuildDone, which is closed when
+// the rebuild finishes. The lock is released while the hash tables are
+// rebuilt on new storage, so only capturing the state and installing the
+// result block other writers, and queries are never blocked. The entries
+// added meanwhile are moved over to the rebuilt tables.
+func (f *LshForest[K]) rebuild(index bool) {
+	var numEntries int
+	if len(f.hashTables) > 0 {
+		numEntries = f.hashTables[0].Len()
+	}
+	tables := make([]hashTable, len(f.hashTables))
+	for i, ht := range f.hashTables {
+		tables[i] = ht.slice(0, numEntries)
+	}
+	keys, numIndexedKeys, dirKs := f.keys, f.numIndexedKeys, f.prefixDirKs
+	removed := f.tombstones
+	// Keys removed from now on go to a new set.
+	f.tombstones = &tombstoneSet[K]{parent: removed}
+	// The keys of pending entries are removed keys, so they have ids.
+	pending := f.pending
+	pendingIDs := make([]uint32, len(pending))
+	f.reinserting = make(map[K]bool, len(pending))
+	for i, p := range pending {
+		pendingIDs[i] = f.keyIDs[p.key]
+		f.reinserting[p.key] = true
+	}
+	f.pending = nil
+	f.mu.Unlock()
+
+	var rebuilt bool
+	if removed.len() > 0 {
+		tables, numIndexedKeys = compactTables(tables, numIndexedKeys, keys, removed)
+		for i := range tables {
+			width := tables[i].width
+			for j, p := range pending {
+				tables[i].append(p.hashKeys[i*width:(i+1)*width], pendingIDs[j])
+			}
+		}
+		rebuilt = true
+	}
+	if index && len(tables) > 0 && tables[0].Len() > numIndexedKeys {
+		for i := range tables {
+			tables[i] = mergeIndex(tables[i], numIndexedKeys)
+		}
+		numIndexedKeys = tables[0].Len()
+		rebuilt = true
+	}
+	var keyIDs map[K]uint32
+	if index && rebuilt && len(tables) > 0 && len(keys) > 2*tables[0].Len() {
+		keys, keyIDs = compactKeys(tables, keys)
+	}
+	dirs := buildPrefixDirs(tables, numIndexedKeys, f.hashValueBits, dirKs)
+
+	f.mu.Lock()
+	if rebuilt {
+		for i := range tables {
+			// Move over the entries added during the rebuild.
+			added := f.hashTables[i].slice(numEntries, f.hashTables[i].Len())
+			tables[i].hashKeys = append(tables[i].hashKeys, added.hashKeys...)
+			for _, id := range added.ids {
+				if keyIDs != nil {
+					key := f.keys[id]
+					newID, exists := keyIDs[key]
+					if !exists {
+						newID = uint32(len(keys))
+						keys = append(keys, key)
+						keyIDs[key] = newID
+					}
+					id = newID
+				}
+				tables[i].ids = append(tables[i].ids, id)
+			}
+		}
+		f.hashTables, f.numIndexedKeys = tables, numIndexedKeys
+	}
+	if keyIDs != nil {
+		f.keys, f.keyIDs = keys, keyIDs
+	} else {
+		// Removing the compacted keys again has no effect, and adding
+		// them again gives them new ids.
+		for key := range removed.all() {
+			if !f.reinserting[key] {
+				delete(f.keyIDs, key)
+			}
+		}
+	}
+	f.tombstones = f.tombstones.detach()
+	f.reinserting = nil
+	f.insertPending()
+	f.publish(dirs)
+	close(f.rebuildDone)
+	f.rebuildDone = nil
+}
+
+// insertPending inserts the pending entries whose old entries have been
+// compacted, the caller must hold the lock.
+func (f *LshForest[K]) insertPending() {
+	pending := f.pending[:0]
+	for _, p := range f.pending {
+		if f.tombstones.has(p.key) {
+			pending = append(pending, p)
+			continue
+		}
+		f.insert(p.key, p.hashKeys)
+	}
+	f.pending = pending
+}
+
+// waitRebuild blocks until the running rebuild, if any, finishes. The
+// caller must hold the lock, which is released while waiting.
+func (f *LshForest[K]) waitRebuild() {
+	for f.rebuildDone != nil {
+		done := f.rebuildDone
+		f.mu.Unlock()
+		<-done
+		f.mu.Lock()
 	}
 }
 
 // Index makes all the keys added searchable.
-func (f *LshForest) Index() {
-	for i := range f.hashTables {
-		sort.Sort(f.hashTables[i])
+// The entries of removed keys are compacted first.
+// Only the keys added since the last call are sorted, and then merged
+// into the indexed keys. Queries keep reading the previous snapshot of the
+// indexed keys until the new one is ready.
+func (f *LshForest[K]) Index() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.waitRebuild()
+	f.rebuildDone = make(chan struct{})
+	f.rebuild(true)
+}
+
+// EnablePrefixDirectory makes the forest keep a directory of the hash key
+// prefixes of the given numbers of hash values per band, which is rebuilt
+// by Index(). Queries using these numbers look up buckets in the directory
+// in constant time instead of by binary search, at the cost of memory.
+// Calling it without any number removes the directories.
+func (f *LshForest[K]) EnablePrefixDirectory(ks ...int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.waitRebuild()
+	f.prefixDirKs = nil
+	for _, k := range ks {
+		if k >= 1 && k <= f.k {
+			f.prefixDirKs = append(f.prefixDirKs, k)
+		}
 	}
-	f.numIndexedKeys = len(f.hashTables[0])
+	f.rebuildDone = make(chan struct{})
+	f.rebuild(false)
+}
+
+// compactKeys returns the key dictionary without the keys that have no
+// entries in the hash tables, and the positions of the keys in it.
+// The ids of the tables are renumbered into new slices, so the tables
+// they were sliced from are not affected.
+func compactKeys[K comparable](tables []hashTable, keys []K) ([]K, map[K]uint32) {
+	const unused = ^uint32(0)
+	remap := make([]uint32, len(keys))
+	for i := range remap {
+		remap[i] = unused
+	}
+	var compacted []K
+	keyIDs := make(map[K]uint32)
+	for _, id := range tables[0].ids {
+		if remap[id] == unused {
+			remap[id] = uint32(len(compacted))
+			keyIDs[keys[id]] = remap[id]
+			compacted = append(compacted, keys[id])
+		}
+	}
+	for i := range tables {
+		ids := make([]uint32, len(tables[i].ids), cap(tables[i].ids))
+		for j, id := range tables[i].ids {
+			ids[j] = remap[id]
+		}
+		tables[i].ids = ids
+	}
+	return compacted, keyIDs
+}
+
+// mergeIndex sorts the entries of the hash table after the sorted prefix of
+// size numSorted, and returns the table with them merged into the prefix.
+// Only the new entries are sorted, so the cost of indexing a small number
+// of new entries is linear to the size of the table.
+// The table may be read concurrently, so it is never modified: the entries
+// are sorted and merged on new storage.
+func mergeIndex(ht hashTable, numSorted int) hashTable {
+	if ht.Len() == numSorted {
+		return ht
+	}
+	tail := newHashTable(ht.width, ht.Len()-numSorted)
+	added := ht.slice(numSorted, ht.Len())
+	tail.hashKeys = append(tail.hashKeys, added.hashKeys...)
+	tail.ids = append(tail.ids, added.ids...)
+	sort.Sort(tail)
+	merged := newHashTable(ht.width, cap(ht.ids))
+	i, j := 0, 0
+	for i < numSorted || j < tail.Len() {
+		if j == tail.Len() || (i < numSorted && bytes.Compare(ht.hashKey(i), tail.hashKey(j)) <= 0) {
+			merged.append(ht.hashKey(i), ht.ids[i])
+			i++
+		} else {
+			merged.append(tail.hashKey(j), tail.ids[j])
+			j++
+		}
+	}
+	return merged
 }
 
 // Query returns candidate keys given the query signature and parameters.
-func (f *LshForest) Query(sig []uint64, K, L int, out chan<- interface{}, done <-chan struct{}) {
-	if K == -1 {
-		K = f.k
+func (f *LshForest[K]) Query(sig []uint64, k, l int, out chan<- K, done <-chan struct{}) {
+	if k == -1 {
+		k = f.k
 	}
-	if L == -1 {
-		L = f.l
+	if l == -1 {
+		l = f.l
 	}
-	prefixSize := f.hashValueSize * K
 	// Generate hash keys
-	hashKeys := f.hashKeys(sig, K)
-	seens := make(map[interface{}]bool)
-	for i := 0; i < L; i++ {
+	f.queryHashKeys(f.hashKeys(sig, k), k, l, out, done)
+}
+
+// queryHashKeys is similar to Query, but takes the hash keys of the query
+// signature, which must be generated using at least k hash values per band.
+func (f *LshForest[K]) queryHashKeys(hashKeys [][]byte, k, l int, out chan<- K, done <-chan struct{}) {
+	prefixBits := f.hashValueBits * k
+	// The snapshot holds the removed keys together with the indexed keys,
+	// so the entries compacted since are not returned.
+	snapshot := f.snapshot.Load()
+	tables, keys, removed := snapshot.tables, snapshot.keys, snapshot.tombstones
+	dirs := snapshot.prefixDirs[k]
+	seens := make(map[uint32]bool)
+	var buf []byte
+	for i := 0; i < l; i++ {
 		// Only search over indexed keys.
-		ht := f.hashTables[i][:f.numIndexedKeys]
+		ht := tables[i]
 		hk := hashKeys[i]
-		k := sort.Search(len(ht), func(x int) bool {
-			return ht[x].hashKey[:prefixSize] >= hk
-		})
-		if k < len(ht) && ht[k].hashKey[:prefixSize] == hk {
-			for j := k; j < len(ht) && ht[j].hashKey[:prefixSize] == hk; j++ {
-				key := ht[j].key
-				if _, seen := seens[key]; seen {
-					continue
-				}
-				seens[key] = true
-				select {
-				case out <- key:
-				case <-done:
-					return
-				}
+		var start, end int
+		if dirs != nil {
+			buf = prefixKey(buf[:0], hk, prefixBits)
+			r := dirs[i][string(buf)]
+			start, end = int(r.start), int(r.end)
+		} else {
+			start = sort.Search(ht.Len(), func(x int) bool {
+				return comparePrefix(ht.hashKey(x), hk, prefixBits) >= 0
+			})
+			end = start
+			for end < ht.Len() && comparePrefix(ht.hashKey(end), hk, prefixBits) == 0 {
+				end++
+			}
+		}
+		for j := start; j < end; j++ {
+			id := ht.ids[j]
+			if seens[id] {
+				continue
+			}
+			seens[id] = true
+			key := keys[id]
+			if removed.has(key) {
+				continue
+			}
+			select {
+			case out <- key:
+			case <-done:
+				return
 			}
 		}
 	}
 }
 
+// NumKeys returns the number of searchable keys, which may include
+// removed keys that are not yet compacted.
+func (f *LshForest[K]) NumKeys() int {
+	snapshot := f.snapshot.Load()
+	if len(snapshot.tables) == 0 {
+		return 0
+	}
+	return snapshot.tables[0].Len()
+}
+
 // OptimalKL returns the optimal K and L for containment search,
 // and the false positive and negative probabilities.
 // where x is the indexed domain size, q is the query domain size,
 // and t is the containment threshold.
-func (f *LshForest) OptimalKL(x, q int, t float64) (optK, optL int, fp, fn float64) {
-	minError := math.MaxFloat64
-	for l := 1; l <= f.l; l++ {
-		for k := 1; k <= f.k; k++ {
-			currFp := probFalsePositive(x, q, l, k, t, integrationPrecision)
-			currFn := probFalseNegative(x, q, l, k, t, integrationPrecision)
-			currErr := currFn + currFp
-			if minError > currErr {
-				minError = currErr
-				optK = k
-				optL = l
-				fp = currFp
-				fn = currFn
-			}
-		}
-	}
-	return
+func (f *LshForest[K]) OptimalKL(x, q int, t float64) (optK, optL int, fp, fn float64) {
+	return optimalKL(f.k, f.l, f.k*f.l, f.hashValueBits, x, q, t)
 }
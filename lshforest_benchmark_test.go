@@ -11,9 +11,39 @@ func Benchmark_LshForest_Insert10000(b *testing.B) {
 		sigs[i] = randomSignature(64, int64(i))
 	}
 	b.ResetTimer()
-	f := NewLshForest16(2, 32, 10000)
+	f := NewLshForest16[string](2, 32, 10000)
 	for i := range sigs {
 		f.Add(strconv.Itoa(i), sigs[i])
 	}
 	f.Index()
 }
+
+func benchmarkLshForestQuery(b *testing.B, prefixDirectory bool) {
+	f := NewLshForest16[string](2, 32, 10000)
+	for i := 0; i < 10000; i++ {
+		f.Add(strconv.Itoa(i), randomSignature(64, int64(i)))
+	}
+	f.Index()
+	if prefixDirectory {
+		f.EnablePrefixDirectory(1, 2)
+	}
+	sig := randomSignature(64, 1)
+	out := make(chan string)
+	go func() {
+		for range out {
+		}
+	}()
+	defer close(out)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		f.Query(sig, 2, 32, out, nil)
+	}
+}
+
+func Benchmark_LshForest_Query10000(b *testing.B) {
+	benchmarkLshForestQuery(b, false)
+}
+
+func Benchmark_LshForest_Query10000PrefixDirectory(b *testing.B) {
+	benchmarkLshForestQuery(b, true)
+}
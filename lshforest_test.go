@@ -2,6 +2,8 @@ package lshensemble
 
 import (
 	"math/rand"
+	"sort"
+	"sync"
 	"testing"
 )
 
@@ -17,7 +19,7 @@ func randomSignature(size int, seed int64) []uint64 {
 func Test_HashKeyFunc16(t *testing.T) {
 	sig := randomSignature(2, 1)
 	f := hashKeyFuncGen(2)
-	hashKey := f(sig)
+	hashKey := f(nil, sig)
 	if len(hashKey) != 2*2 {
 		t.Fatal(len(hashKey))
 	}
@@ -26,14 +28,14 @@ func Test_HashKeyFunc16(t *testing.T) {
 func Test_HashKeyFunc64(t *testing.T) {
 	sig := randomSignature(2, 1)
 	f := hashKeyFuncGen(8)
-	hashKey := f(sig)
+	hashKey := f(nil, sig)
 	if len(hashKey) != 8*2 {
 		t.Fatal(len(hashKey))
 	}
 }
 
 func Test_LshForest(t *testing.T) {
-	f := NewLshForest16(2, 4, 3)
+	f := NewLshForest16[string](2, 4, 3)
 	sig1 := randomSignature(8, 2)
 	sig2 := randomSignature(8, 1)
 	sig3 := randomSignature(8, 1)
@@ -44,12 +46,12 @@ func Test_LshForest(t *testing.T) {
 
 	f.Index()
 	for i := range f.hashTables {
-		if len(f.hashTables[i]) != 3 {
+		if f.hashTables[i].Len() != 3 {
 			t.Fatal(f.hashTables[i])
 		}
 	}
 
-	keys := make(chan interface{})
+	keys := make(chan string)
 	done := make(chan struct{})
 	defer close(done)
 	go func() {
@@ -68,6 +70,265 @@ func Test_LshForest(t *testing.T) {
 }
 
 func Test_LshForest_OptimalKL(t *testing.T) {
-	f := NewLshForest16(2, 32, 1)
+	f := NewLshForest16[string](2, 32, 1)
 	t.Log(f.OptimalKL(32, 12, 0.5))
 }
+
+func queryLshForest[K comparable](f Lsh[K], sig []uint64, k, l int) map[K]bool {
+	keys := make(chan K)
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		f.Query(sig, k, l, keys, done)
+		close(keys)
+	}()
+	found := make(map[K]bool)
+	for key := range keys {
+		found[key] = true
+	}
+	return found
+}
+
+func Test_LshForestRemoveUpdate(t *testing.T) {
+	f := NewLshForest16[string](2, 4, 3)
+	sig1 := randomSignature(8, 1)
+	sig2 := randomSignature(8, 2)
+	f.Add("sig1", sig1)
+	f.Add("sig2", sig2)
+	f.Index()
+
+	f.Remove("sig1")
+	if queryLshForest(f, sig1, 2, 4)["sig1"] {
+		t.Fatal("Removed key was returned")
+	}
+	f.Update("sig2", sig1)
+	f.Index()
+	if f.hashTables[0].Len() != 1 {
+		t.Fatal("Removed entries were not compacted")
+	}
+	if !queryLshForest(f, sig1, 2, 4)["sig2"] {
+		t.Fatal("Unable to retrieve updated key with new signature")
+	}
+	if queryLshForest(f, sig2, 2, 4)["sig2"] {
+		t.Fatal("Updated key was returned for its old signature")
+	}
+}
+
+func Test_LshForestBackgroundCompaction(t *testing.T) {
+	f := NewLshForest16[int](2, 4, 100)
+	for i := 0; i < 100; i++ {
+		f.Add(i, randomSignature(8, int64(i)))
+	}
+	f.Index()
+	for i := 0; i < 20; i++ {
+		f.Remove(i)
+	}
+	f.mu.Lock()
+	f.waitRebuild()
+	numEntries := f.hashTables[0].Len()
+	f.mu.Unlock()
+	if numEntries >= 100 {
+		t.Fatal("Background compaction did not run")
+	}
+	for i := 0; i < 100; i++ {
+		found := queryLshForest(f, randomSignature(8, int64(i)), 2, 4)[i]
+		if found != (i >= 20) {
+			t.Fatalf("Key %d found: %v", i, found)
+		}
+	}
+}
+
+func Test_LshForestIncrementalIndex(t *testing.T) {
+	f := NewLshForest16[int](2, 4, 100)
+	for i := 0; i < 100; i++ {
+		f.Add(i, randomSignature(8, int64(i)))
+		if i%7 == 0 {
+			f.Index()
+		}
+	}
+	f.Index()
+	for i := range f.hashTables {
+		if !sort.IsSorted(f.hashTables[i]) {
+			t.Fatal("Hash table is not sorted")
+		}
+	}
+	for i := 0; i < 100; i++ {
+		if !queryLshForest(f, randomSignature(8, int64(i)), 2, 4)[i] {
+			t.Fatalf("Unable to retrieve key %d", i)
+		}
+	}
+}
+
+func Test_LshForestKeyDictionary(t *testing.T) {
+	f := NewLshForest16[int](2, 4, 100)
+	for i := 0; i < 100; i++ {
+		f.Add(i, randomSignature(8, int64(i)))
+	}
+	f.Index()
+	for i := 0; i < 80; i++ {
+		f.Remove(i)
+	}
+	f.Add(0, randomSignature(8, 0))
+	f.Index()
+	if len(f.keys) != 21 || len(f.keyIDs) != 21 {
+		t.Fatalf("Key dictionary has %d keys, expecting 21", len(f.keys))
+	}
+	for i := 0; i < 100; i++ {
+		found := queryLshForest(f, randomSignature(8, int64(i)), 2, 4)[i]
+		if found != (i == 0 || i >= 80) {
+			t.Fatalf("Key %d found: %v", i, found)
+		}
+	}
+}
+
+func Test_LshForestRemoveUnknown(t *testing.T) {
+	f := NewLshForest16[int](2, 4, 10)
+	for i := 0; i < 10; i++ {
+		f.Add(i, randomSignature(8, int64(i)))
+	}
+	f.Index()
+	f.Remove(100)
+	if f.tombstones.len() != 0 || f.rebuildDone != nil {
+		t.Fatal("Removing an unknown key left a tombstone")
+	}
+	f.Add(100, randomSignature(8, 100))
+	if len(f.pending) != 0 {
+		t.Fatal("Adding a key removed before it was added is pending")
+	}
+	f.Index()
+	if !queryLshForest(f, randomSignature(8, 100), 2, 4)[100] {
+		t.Fatal("Unable to retrieve the added key")
+	}
+}
+
+func Test_LshForestNoBands(t *testing.T) {
+	f := NewLshForest16[int](2, 0, 0)
+	f.Add(1, randomSignature(8, 1))
+	f.Index()
+	f.Remove(1)
+	f.Update(1, randomSignature(8, 2))
+	f.Index()
+	if n := f.NumKeys(); n != 0 {
+		t.Fatalf("Forest without bands has %d keys", n)
+	}
+	if len(queryLshForest(f, randomSignature(8, 1), 2, 0)) != 0 {
+		t.Fatal("Forest without bands returned candidates")
+	}
+}
+
+func Test_TombstoneSet(t *testing.T) {
+	s := &tombstoneSet[int]{}
+	for i := 0; i < 100; i++ {
+		prev := s
+		s = s.with(i)
+		if prev.has(i) || !s.has(i) {
+			t.Fatalf("Adding key %d changed the previous set", i)
+		}
+	}
+	if s.len() != 100 || len(s.recent) > 10 {
+		t.Fatalf("Set has %d keys, %d recent", s.len(), len(s.recent))
+	}
+	child := (&tombstoneSet[int]{parent: s}).with(100).with(0)
+	if child.len() != 102 || !child.has(50) {
+		t.Fatal("Child set does not hold the keys of the parent")
+	}
+	if d := child.detach(); d.len() != 2 || !d.has(0) || !d.has(100) || d.has(50) {
+		t.Fatal("Detached set holds the keys of the parent")
+	}
+}
+
+func Test_LshForestConcurrentRemove(t *testing.T) {
+	f := NewLshForest16[int](2, 4, 400)
+	for i := 0; i < 400; i++ {
+		f.Add(i, randomSignature(8, int64(i)))
+	}
+	f.Index()
+	var wg sync.WaitGroup
+	stop := make(chan struct{})
+	// Readers query and writers index while keys are removed and updated.
+	for r := 0; r < 2; r++ {
+		wg.Add(1)
+		go func(r int) {
+			defer wg.Done()
+			for i := r; ; i = (i + 1) % 400 {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				queryLshForest(f, randomSignature(8, int64(i)), 2, 4)
+			}
+		}(r)
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			f.Index()
+		}
+	}()
+	for i := 0; i < 300; i++ {
+		if i < 200 {
+			f.Remove(i)
+		} else {
+			f.Update(i, randomSignature(8, int64(i+1000)))
+		}
+	}
+	close(stop)
+	wg.Wait()
+	f.Index()
+	for i := 0; i < 400; i++ {
+		if queryLshForest(f, randomSignature(8, int64(i)), 2, 4)[i] != (i >= 300) {
+			t.Fatalf("Key %d found for its old signature: %v", i, i < 300)
+		}
+		if i >= 200 && i < 300 && !queryLshForest(f, randomSignature(8, int64(i+1000)), 2, 4)[i] {
+			t.Fatalf("Unable to retrieve updated key %d", i)
+		}
+	}
+	if n := f.NumKeys(); n != 200 {
+		t.Fatalf("Forest has %d keys, expecting 200", n)
+	}
+}
+
+func Test_LshForestPrefixDirectory(t *testing.T) {
+	for _, bits := range []int{16, 3} {
+		f := newLshForest[int](4, 4, bits, 100)
+		g := newLshForest[int](4, 4, bits, 100)
+		g.EnablePrefixDirectory(1, 2, 3, 4)
+		for i := 0; i < 100; i++ {
+			f.Add(i, randomSignature(16, int64(i)))
+			g.Add(i, randomSignature(16, int64(i)))
+		}
+		f.Index()
+		g.Index()
+		for i := 0; i < 20; i++ {
+			f.Remove(i)
+			g.Remove(i)
+		}
+		g.Index()
+		if len(g.snapshot.Load().prefixDirs) != 4 {
+			t.Fatalf("Forest has %d prefix directories, expecting 4", len(g.snapshot.Load().prefixDirs))
+		}
+		for i := 0; i < 100; i++ {
+			sig := randomSignature(16, int64(i))
+			for k := 1; k <= 4; k++ {
+				expected := queryLshForest(f, sig, k, 4)
+				found := queryLshForest(g, sig, k, 4)
+				if len(found) != len(expected) {
+					t.Fatalf("Query of key %d with k = %d found %v, expecting %v",
+						i, k, found, expected)
+				}
+				for key := range expected {
+					if !found[key] {
+						t.Fatalf("Query of key %d with k = %d did not find %d", i, k, key)
+					}
+				}
+			}
+		}
+	}
+}
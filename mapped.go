@@ -0,0 +1,385 @@
+package lshensemble
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"math"
+	"os"
+)
+
+var (
+	errReadOnly  = errors.New("Memory-mapped index is read-only")
+	errTruncated = errors.New("Index file is truncated")
+)
+
+// byteCursor reads the index format from a byte slice without copying.
+type byteCursor struct {
+	data []byte
+	off  int
+	err  error
+}
+
+func (c *byteCursor) next(n int) []byte {
+	if c.err != nil {
+		return nil
+	}
+	if n < 0 || n > len(c.data)-c.off {
+		c.err = errTruncated
+		return nil
+	}
+	b := c.data[c.off : c.off+n]
+	c.off += n
+	return b
+}
+
+func (c *byteCursor) u8() byte {
+	b := c.next(1)
+	if b == nil {
+		return 0
+	}
+	return b[0]
+}
+
+func (c *byteCursor) u32() uint32 {
+	b := c.next(4)
+	if b == nil {
+		return 0
+	}
+	return binary.LittleEndian.Uint32(b)
+}
+
+func (c *byteCursor) u64() uint64 {
+	b := c.next(8)
+	if b == nil {
+		return 0
+	}
+	return binary.LittleEndian.Uint64(b)
+}
+
+// MappedLshForest is a read-only LshForest backed by a memory-mapped index
+// file written by LshEnsemble.WriteTo. The hash tables are binary-searched
+// directly in the mapped memory, and keys are decoded only when they are
+// emitted by a query.
+// Keys that were added but not indexed at the time of writing are not
+// searchable.
+type MappedLshForest[K comparable] struct {
+	k              int
+	l              int
+	hashValueBits  int
+	numIndexedKeys int
+	numKeys        int
+	keyOffsets     []byte
+	keyBlob        []byte
+	hashTables     [][]byte
+	ids            [][]byte
+}
+
+func readMappedLshForest[K comparable](c *byteCursor, version uint32, wantK, wantL int) (*MappedLshForest[K], error) {
+	f := &MappedLshForest[K]{
+		k: int(c.u32()),
+		l: int(c.u32()),
+	}
+	stored := int(c.u32())
+	numEntries := int(c.u64())
+	f.numIndexedKeys = int(c.u64())
+	f.numKeys = int(c.u64())
+	if c.err != nil {
+		return nil, c.err
+	}
+	var err error
+	if f.hashValueBits, err = hashValueBits(version, stored); err != nil {
+		return nil, err
+	}
+	width := hashKeyWidth(wantK, f.hashValueBits)
+	if err := checkForestHeader(f.k, f.l, wantK, wantL, width, numEntries, f.numIndexedKeys, f.numKeys); err != nil {
+		return nil, err
+	}
+	f.keyOffsets = c.next(8 * (f.numKeys + 1))
+	if c.err != nil {
+		return nil, c.err
+	}
+	blobSize := binary.LittleEndian.Uint64(f.keyOffsets[8*f.numKeys:])
+	if blobSize > math.MaxInt {
+		return nil, errors.New("Invalid key offsets")
+	}
+	f.keyBlob = c.next(int(blobSize))
+	f.hashTables = make([][]byte, f.l)
+	f.ids = make([][]byte, f.l)
+	for i := 0; i < f.l; i++ {
+		hashKeys := c.next(width * numEntries)
+		ids := c.next(4 * numEntries)
+		if c.err != nil {
+			return nil, c.err
+		}
+		// Only the indexed prefix of each table is sorted.
+		f.hashTables[i] = hashKeys[:width*f.numIndexedKeys]
+		f.ids[i] = ids[:4*f.numIndexedKeys]
+	}
+	return f, c.err
+}
+
+// Add is a no-op, a memory-mapped index is read-only. LshEnsemble returns
+// an error instead of calling it.
+func (f *MappedLshForest[K]) Add(key K, sig []uint64) {}
+
+// Remove is a no-op, a memory-mapped index is read-only.
+func (f *MappedLshForest[K]) Remove(key K) {}
+
+// Update is a no-op, a memory-mapped index is read-only.
+func (f *MappedLshForest[K]) Update(key K, sig []uint64) {}
+
+func (f *MappedLshForest[K]) readOnly() {}
+
+// Index is a no-op, all keys in a memory-mapped index are already indexed.
+func (f *MappedLshForest[K]) Index() {}
+
+func (f *MappedLshForest[K]) key(id uint32) (K, error) {
+	var key K
+	if int(id) >= f.numKeys {
+		return key, errors.New("Invalid key id")
+	}
+	start := binary.LittleEndian.Uint64(f.keyOffsets[8*id:])
+	end := binary.LittleEndian.Uint64(f.keyOffsets[8*(id+1):])
+	if start > end || end > uint64(len(f.keyBlob)) {
+		return key, errors.New("Invalid key offsets")
+	}
+	return decodeKeyAs[K](f.keyBlob[start:end])
+}
+
+// Query returns candidate keys given the query signature and parameters.
+// Corrupted keys are skipped.
+func (f *MappedLshForest[K]) Query(sig []uint64, k, l int, out chan<- K, done <-chan struct{}) {
+	if k == -1 {
+		k = f.k
+	}
+	if l == -1 {
+		l = f.l
+	}
+	width := hashKeyWidth(f.k, f.hashValueBits)
+	prefixBits := f.hashValueBits * k
+	hashKeyFunc := hashKeyFuncFor(f.hashValueBits)
+	seens := make(map[uint32]bool)
+	for i := 0; i < l; i++ {
+		ht := f.hashTables[i]
+		hk := hashKeyFunc(nil, sig[i*f.k:i*f.k+k])
+		hashKey := func(x int) []byte {
+			return ht[x*width : (x+1)*width]
+		}
+		// Binary search for the first hash key with the query prefix.
+		lo, hi := 0, f.numIndexedKeys
+		for lo < hi {
+			h := int(uint(lo+hi) >> 1)
+			if comparePrefix(hashKey(h), hk, prefixBits) < 0 {
+				lo = h + 1
+			} else {
+				hi = h
+			}
+		}
+		for j := lo; j < f.numIndexedKeys && comparePrefix(hashKey(j), hk, prefixBits) == 0; j++ {
+			id := binary.LittleEndian.Uint32(f.ids[i][4*j:])
+			if seens[id] {
+				continue
+			}
+			seens[id] = true
+			key, err := f.key(id)
+			if err != nil {
+				continue
+			}
+			select {
+			case out <- key:
+			case <-done:
+				return
+			}
+		}
+	}
+}
+
+// NumKeys returns the number of searchable keys.
+func (f *MappedLshForest[K]) NumKeys() int {
+	return f.numIndexedKeys
+}
+
+// OptimalKL returns the optimal K and L for containment search,
+// and the false positive and negative probabilities.
+// where x is the indexed domain size, q is the query domain size,
+// and t is the containment threshold.
+func (f *MappedLshForest[K]) OptimalKL(x, q int, t float64) (optK, optL int, fp, fn float64) {
+	return optimalKL(f.k, f.l, f.k*f.l, f.hashValueBits, x, q, t)
+}
+
+// MappedLshForestArray is the memory-mapped counterpart of LshForestArray.
+type MappedLshForestArray[K comparable] struct {
+	maxK    int
+	numHash int
+	array   []*MappedLshForest[K]
+}
+
+// Add is a no-op, a memory-mapped index is read-only. LshEnsemble returns
+// an error instead of calling it.
+func (a *MappedLshForestArray[K]) Add(key K, sig []uint64) {}
+
+// Remove is a no-op, a memory-mapped index is read-only.
+func (a *MappedLshForestArray[K]) Remove(key K) {}
+
+// Update is a no-op, a memory-mapped index is read-only.
+func (a *MappedLshForestArray[K]) Update(key K, sig []uint64) {}
+
+func (a *MappedLshForestArray[K]) readOnly() {}
+
+// Index is a no-op, all keys in a memory-mapped index are already indexed.
+func (a *MappedLshForestArray[K]) Index() {}
+
+// Query returns candidate keys given the query signature and parameters.
+func (a *MappedLshForestArray[K]) Query(sig []uint64, k, l int, out chan<- K, done <-chan struct{}) {
+	a.array[k-1].Query(sig, -1, l, out, done)
+}
+
+// NumKeys returns the number of searchable keys.
+func (a *MappedLshForestArray[K]) NumKeys() int {
+	return a.array[0].NumKeys()
+}
+
+// OptimalKL returns the optimal K and L for containment search,
+// and the false positive and negative probabilities.
+// where x is the indexed domain size, q is the query domain size,
+// and t is the containment threshold.
+func (a *MappedLshForestArray[K]) OptimalKL(x, q int, t float64) (optK, optL int, fp, fn float64) {
+	return optimalKL(a.maxK, a.numHash, a.numHash, a.array[0].hashValueBits, x, q, t)
+}
+
+// OpenLshEnsemble opens an index file written by LshEnsemble.WriteTo
+// without deserializing it. The file is memory-mapped and queried in place,
+// so opening is fast even when the index is larger than the available
+// memory. Verifying the checksum reads the whole file, and can be skipped
+// by setting verifyChecksum to false.
+// The returned index is read-only, and must be closed using Close() after use.
+func OpenLshEnsemble[K comparable](path string, verifyChecksum bool) (*LshEnsemble[K], error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if info.Size() < int64(len(indexMagic))+4 {
+		return nil, errTruncated
+	}
+	data, err := mmapFile(file, int(info.Size()))
+	if err != nil {
+		return nil, err
+	}
+	e, err := readMappedLshEnsemble[K](data, verifyChecksum)
+	if err != nil {
+		munmapFile(data)
+		return nil, err
+	}
+	e.closer = func() error { return munmapFile(data) }
+	return e, nil
+}
+
+func readMappedLshEnsemble[K comparable](data []byte, verifyChecksum bool) (*LshEnsemble[K], error) {
+	body := data[:len(data)-4]
+	if verifyChecksum &&
+		crc32.ChecksumIEEE(body) != binary.LittleEndian.Uint32(data[len(body):]) {
+		return nil, errBadChecksum
+	}
+	c := &byteCursor{data: body}
+	if string(c.next(len(indexMagic))) != indexMagic {
+		return nil, errBadMagic
+	}
+	version := c.u32()
+	if c.err == nil && (version < 1 || version > indexVersion) {
+		return nil, fmt.Errorf("Unsupported index version %d", version)
+	}
+	numHash := int(c.u32())
+	maxK := int(c.u32())
+	kind := c.u8()
+	var o options
+	if version >= 3 {
+		sigInfo := SignatureInfo{
+			Family:  HashFamily(c.u8()),
+			Seed:    int64(c.u64()),
+			NumHash: numHash,
+		}
+		if sigInfo.Family != 0 {
+			o.sigInfo = &sigInfo
+		}
+	}
+	if version >= 4 {
+		hasNormalizer := c.u8()
+		flags := c.u8()
+		custom := c.next(int(c.u32()))
+		if c.err != nil {
+			return nil, c.err
+		}
+		if hasNormalizer != 0 {
+			var err error
+			if o.normalizer, err = newNormalizer(flags, string(custom)); err != nil {
+				return nil, err
+			}
+		}
+	}
+	var overflow bool
+	if version >= 5 {
+		o.routing = RoutingPolicy(c.u8())
+		overflow = c.u8() != 0
+	}
+	if version >= 6 {
+		o.sizeError = math.Float64frombits(c.u64())
+	}
+	numPart := int(c.u32())
+	if c.err != nil {
+		return nil, c.err
+	}
+	if o.routing > OverflowPartition {
+		return nil, fmt.Errorf("Unknown routing policy %d", o.routing)
+	}
+	if !(o.sizeError >= 0 && o.sizeError <= 1) {
+		return nil, errors.New("Invalid size error")
+	}
+	if kind != lshKindForest && kind != lshKindForestArray {
+		return nil, fmt.Errorf("Unknown Lsh kind %d", kind)
+	}
+	if maxK <= 0 || numHash < maxK || numHash > maxNumHash {
+		return nil, errors.New("Invalid numHash or maxK")
+	}
+	if numPart < 0 || numPart > (len(c.data)-c.off)/16 {
+		return nil, errTruncated
+	}
+	parts := make([]Partition, numPart)
+	for i := range parts {
+		parts[i].Lower = int(int64(c.u64()))
+		parts[i].Upper = int(int64(c.u64()))
+	}
+	lshes := make([]Lsh[K], numPart)
+	for i := range lshes {
+		if kind == lshKindForest {
+			f, err := readMappedLshForest[K](c, version, maxK, numHash/maxK)
+			if err != nil {
+				return nil, err
+			}
+			lshes[i] = f
+			continue
+		}
+		a := &MappedLshForestArray[K]{
+			maxK:    maxK,
+			numHash: numHash,
+			array:   make([]*MappedLshForest[K], maxK),
+		}
+		for j := range a.array {
+			f, err := readMappedLshForest[K](c, version, j+1, numHash/(j+1))
+			if err != nil {
+				return nil, err
+			}
+			a.array[j] = f
+		}
+		lshes[i] = a
+	}
+	e := newLshEnsemble(parts, lshes, nil, numHash, maxK, o)
+	e.overflow = overflow
+	return e, nil
+}
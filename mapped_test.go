@@ -0,0 +1,72 @@
+package lshensemble
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+)
+
+func Test_OpenLshEnsemble(t *testing.T) {
+	recs := testDomainRecords(100, 64)
+	for _, index := range testIndexes(t, recs) {
+		path := filepath.Join(t.TempDir(), "index.lshe")
+		file, err := os.Create(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := index.WriteTo(file); err != nil {
+			t.Fatal(err)
+		}
+		file.Close()
+
+		mapped, err := OpenLshEnsemble[string](path, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, rec := range recs {
+			expected, _, err := index.QueryTimed(rec.Signature, rec.Size, 0.8)
+			if err != nil {
+				t.Fatal(err)
+			}
+			result, _, err := mapped.QueryTimed(rec.Signature, rec.Size, 0.8)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(expected) != len(result) {
+				t.Fatalf("Expected %d candidates, got %d", len(expected), len(result))
+			}
+			sort.Strings(result)
+			if i := sort.SearchStrings(result, rec.Key); i == len(result) || result[i] != rec.Key {
+				t.Fatalf("Unable to retrieve key %s", rec.Key)
+			}
+		}
+		rec := recs[0]
+		if err := mapped.Add(rec.Key, rec.Signature, 0); err != errReadOnly {
+			t.Errorf("Add returned %v, expecting %v", err, errReadOnly)
+		}
+		if err := mapped.Prepare(rec.Key, rec.Signature, rec.Size); err != errReadOnly {
+			t.Errorf("Prepare returned %v, expecting %v", err, errReadOnly)
+		}
+		if err := mapped.Update(rec.Key, rec.Signature, rec.Size); err != errReadOnly {
+			t.Errorf("Update returned %v, expecting %v", err, errReadOnly)
+		}
+		if err := mapped.Remove(rec.Key); err != errReadOnly {
+			t.Errorf("Remove returned %v, expecting %v", err, errReadOnly)
+		}
+		// The Lsh of the partitions ignore changes.
+		for _, lsh := range mapped.lshes {
+			n := lsh.NumKeys()
+			lsh.Add(rec.Key, rec.Signature)
+			lsh.Update(rec.Key, rec.Signature)
+			lsh.Remove(rec.Key)
+			lsh.Index()
+			if lsh.NumKeys() != n {
+				t.Errorf("Read-only Lsh has %d keys, expecting %d", lsh.NumKeys(), n)
+			}
+		}
+		if err := mapped.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
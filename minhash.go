@@ -3,57 +3,246 @@ package lshensemble
 import (
 	"bytes"
 	"encoding/binary"
+	"errors"
+	"fmt"
 	"hash/fnv"
+	"math"
 	"math/rand"
 
+	"github.com/cespare/xxhash/v2"
 	minwise "github.com/dgryski/go-minhash"
+	"github.com/spaolacci/murmur3"
 )
 
 // HashValueSize is 8, the number of byte used for each hash value
 const HashValueSize = 8
 
+var (
+	errSeedMismatch    = errors.New("Cannot merge MinHash objects with different seeds or hash families")
+	errNumHashMismatch = errors.New("Cannot merge signatures with different numbers of hash functions")
+	errHllMismatch     = errors.New("Cannot merge MinHash objects with and without cardinality estimation")
+)
+
 // Minhash represents a MinHash object
 type Minhash struct {
+	// mw computes the signature using two hash functions, nil for
+	// the datasketch hash family.
 	mw *minwise.MinWise
+	// ds computes the signature for the datasketch hash family.
+	ds     *datasketchMinhash
+	family HashFamily
+	seed   int64
+	// hll estimates the domain size in the same pass, nil unless
+	// EstimateCardinality is set.
+	hll *HyperLogLog
+}
+
+// MinhashOptions configures the hash functions of a Minhash.
+type MinhashOptions struct {
+	// Family is the hash family, one of HashFamilyMinhash (seeded FNV-1a),
+	// HashFamilyXXHash, HashFamilyMurmur3 and HashFamilyDatasketch.
+	// The default is HashFamilyMinhash.
+	Family HashFamily
+	// EstimateCardinality makes the Minhash estimate the number of
+	// distinct values pushed, see Cardinality. It costs 4 KiB of memory
+	// and an extra hash per pushed value.
+	EstimateCardinality bool
 }
 
 // NewMinhash initializes a MinHash object with a seed and the number of
 // hash functions.
 func NewMinhash(seed int64, numHash int) *Minhash {
+	return NewMinhashWithOptions(seed, numHash, MinhashOptions{})
+}
+
+// NewMinhashWithOptions is similar to NewMinhash, but uses the hash
+// functions configured by opts.
+func NewMinhashWithOptions(seed int64, numHash int, opts MinhashOptions) *Minhash {
+	var hll *HyperLogLog
+	if opts.EstimateCardinality {
+		hll = NewHyperLogLog(minhashHllPrecision)
+	}
+	if opts.Family == 0 {
+		opts.Family = HashFamilyMinhash
+	}
+	if opts.Family == HashFamilyDatasketch {
+		return &Minhash{
+			ds:     newDatasketchMinhash(seed, numHash, hll),
+			family: opts.Family,
+			seed:   seed,
+			hll:    hll,
+		}
+	}
 	r := rand.New(rand.NewSource(seed))
-	b := binary.BigEndian
-	b1 := make([]byte, HashValueSize)
-	b2 := make([]byte, HashValueSize)
-	b.PutUint64(b1, uint64(r.Int63()))
-	b.PutUint64(b2, uint64(r.Int63()))
-	fnv1 := fnv.New64a()
-	fnv2 := fnv.New64a()
-	h1 := func(b []byte) uint64 {
-		fnv1.Reset()
-		fnv1.Write(b1)
-		fnv1.Write(b)
-		return fnv1.Sum64()
-	}
-	h2 := func(b []byte) uint64 {
-		fnv2.Reset()
-		fnv2.Write(b2)
-		fnv2.Write(b)
-		return fnv2.Sum64()
-	}
-	return &Minhash{minwise.NewMinWise(h1, h2, numHash)}
+	seed1, seed2 := uint64(r.Int63()), uint64(r.Int63())
+	var h1, h2 minwise.Hash64
+	switch opts.Family {
+	case HashFamilyMinhash:
+		b := binary.BigEndian
+		b1 := make([]byte, HashValueSize)
+		b2 := make([]byte, HashValueSize)
+		b.PutUint64(b1, seed1)
+		b.PutUint64(b2, seed2)
+		fnv1 := fnv.New64a()
+		fnv2 := fnv.New64a()
+		h1 = func(b []byte) uint64 {
+			fnv1.Reset()
+			fnv1.Write(b1)
+			fnv1.Write(b)
+			return fnv1.Sum64()
+		}
+		h2 = func(b []byte) uint64 {
+			fnv2.Reset()
+			fnv2.Write(b2)
+			fnv2.Write(b)
+			return fnv2.Sum64()
+		}
+	case HashFamilyXXHash:
+		xx1 := xxhash.NewWithSeed(seed1)
+		xx2 := xxhash.NewWithSeed(seed2)
+		h1 = func(b []byte) uint64 {
+			xx1.ResetWithSeed(seed1)
+			xx1.Write(b)
+			return xx1.Sum64()
+		}
+		h2 = func(b []byte) uint64 {
+			xx2.ResetWithSeed(seed2)
+			xx2.Write(b)
+			return xx2.Sum64()
+		}
+	case HashFamilyMurmur3:
+		h1 = func(b []byte) uint64 {
+			return murmur3.Sum64WithSeed(b, uint32(seed1))
+		}
+		h2 = func(b []byte) uint64 {
+			return murmur3.Sum64WithSeed(b, uint32(seed2))
+		}
+	default:
+		panic(fmt.Sprintf("Unsupported Minhash hash family %s", opts.Family))
+	}
+	if hll != nil {
+		first := h1
+		h1 = func(b []byte) uint64 {
+			v := first(b)
+			hll.addHash(splitMix64(v))
+			return v
+		}
+	}
+	return &Minhash{
+		mw:     minwise.NewMinWise(h1, h2, numHash),
+		family: opts.Family,
+		seed:   seed,
+		hll:    hll,
+	}
 }
 
 // Push a new value to the MinHash object.
 // The value should be serialized to byte slice.
 func (m *Minhash) Push(b []byte) {
+	if m.ds != nil {
+		m.ds.push(b)
+		return
+	}
 	m.mw.Push(b)
 }
 
 // Signature exports the MinHash signature.
 func (m *Minhash) Signature() []uint64 {
+	if m.ds != nil {
+		return m.ds.hashValues
+	}
 	return m.mw.Signature()
 }
 
+// Merge combines the MinHash of another domain, so the signature becomes
+// the signature of the union of the two domains. The two MinHash objects
+// must have the same seed and number of hash functions.
+func (m *Minhash) Merge(other *Minhash) error {
+	if m.seed != other.seed || m.family != other.family {
+		return errSeedMismatch
+	}
+	if len(m.Signature()) != len(other.Signature()) {
+		return errNumHashMismatch
+	}
+	if (m.hll == nil) != (other.hll == nil) {
+		return errHllMismatch
+	}
+	if m.ds != nil {
+		m.ds.merge(other.ds)
+	} else {
+		m.mw.Merge(other.mw)
+	}
+	if m.hll == nil {
+		return nil
+	}
+	return m.hll.Merge(other.hll)
+}
+
+// MergeSignatures returns the signature of the union of two domains given
+// their signatures, which is the element-wise minimum.
+// The signatures must be generated using the same seed.
+func MergeSignatures(a, b []uint64) ([]uint64, error) {
+	if len(a) != len(b) {
+		return nil, errNumHashMismatch
+	}
+	sig := make([]uint64, len(a))
+	for i := range a {
+		sig[i] = a[i]
+		if b[i] < sig[i] {
+			sig[i] = b[i]
+		}
+	}
+	return sig, nil
+}
+
+// MergedSize returns the estimated size of the union of two domains,
+// |A \union B| = (|A| + |B|) / (1 + J), where J is the Jaccard similarity
+// estimated from the signatures a and b of A and B respectively.
+// The result can be used as the size of the merged domain without
+// rescanning the values.
+func MergedSize(a, b []uint64, aSize, bSize int) int {
+	if len(a) == 0 || len(a) != len(b) {
+		return aSize + bSize
+	}
+	var eq int
+	for i := range a {
+		if a[i] == b[i] {
+			eq++
+		}
+	}
+	jaccard := float64(eq) / float64(len(a))
+	size := int(math.Round(float64(aSize+bSize) / (1.0 + jaccard)))
+	// The union is at least as large as the larger domain.
+	if size < aSize {
+		size = aSize
+	}
+	if size < bSize {
+		size = bSize
+	}
+	return size
+}
+
+// Cardinality returns the estimated number of distinct values pushed,
+// which can be used as the domain size instead of the exact size.
+// The estimate is computed using HyperLogLog in the same pass as the
+// signature, and its relative standard error is CardinalityError.
+// It returns 0 unless the Minhash was created with EstimateCardinality.
+func (m *Minhash) Cardinality() int {
+	if m.hll == nil {
+		return 0
+	}
+	return m.hll.Cardinality()
+}
+
+// CardinalityError returns the relative standard error of Cardinality,
+// 0 unless the Minhash was created with EstimateCardinality.
+func (m *Minhash) CardinalityError() float64 {
+	if m.hll == nil {
+		return 0
+	}
+	return m.hll.RelativeError()
+}
+
 // Containment returns the estimated containment of
 // |Q \intersect X| / |Q|.
 // q and x are the signatures of Q and X respectively.
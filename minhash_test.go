@@ -11,6 +11,91 @@ func TestMinhash(t *testing.T) {
 	m.Push([]byte("Test some input"))
 }
 
+func TestMinhashDefaultHash(t *testing.T) {
+	// The default hash functions must not change, so existing
+	// signatures and indexes keep working.
+	m := NewMinhash(1, 4)
+	m.Push([]byte("a"))
+	m.Push([]byte("b"))
+	expected := []uint64{0x46e379980e457f70, 0x6c4f23b61068346d, 0x91bacad4128ae451, 0xb72671f214ad9435}
+	for i, v := range m.Signature() {
+		if v != expected[i] {
+			t.Fatalf("Hash value %d is %#x, expecting %#x", i, v, expected[i])
+		}
+	}
+}
+
+func TestMinhashHashFamilies(t *testing.T) {
+	d := data(2000)
+	for _, family := range []HashFamily{HashFamilyMinhash, HashFamilyXXHash,
+		HashFamilyMurmur3, HashFamilyDatasketch} {
+		opts := MinhashOptions{Family: family}
+		m1 := NewMinhashWithOptions(1, 256, opts)
+		m2 := NewMinhashWithOptions(1, 256, opts)
+		hashing(m1, 0, 1500, d)
+		hashing(m2, 500, 2000, d)
+		if info := m1.SignatureInfo(); info.Family != family {
+			t.Errorf("Signature info has hash family %s, expecting %s", info.Family, family)
+		}
+		est := Containment(m1.Signature(), m2.Signature(), 1500, 1500)
+		if math.Abs(est-1000.0/1500.0) > 0.1 {
+			t.Errorf("%s: estimated containment %f, actual %f", family, est, 1000.0/1500.0)
+		}
+		if err := m1.Merge(NewMinhash(1, 256)); family != HashFamilyMinhash && err == nil {
+			t.Errorf("%s: merging with a different hash family should fail", family)
+		}
+	}
+}
+
+func TestMT19937(t *testing.T) {
+	// The first output of the reference implementation with the
+	// default seed.
+	if v := newMT19937(5489).next32(); v != 3499211612 {
+		t.Fatalf("First output %d, expecting 3499211612", v)
+	}
+	// numpy.random.RandomState(0).random_sample()
+	mt := newMT19937(0)
+	a, b := mt.next32()>>5, mt.next32()>>6
+	if v := (float64(a)*67108864.0 + float64(b)) / 9007199254740992.0; v != 0.5488135039273248 {
+		t.Fatalf("First random sample %v, expecting 0.5488135039273248", v)
+	}
+}
+
+func TestMinhashMerge(t *testing.T) {
+	d := data(1000)
+	m1 := NewMinhash(1, 256)
+	m2 := NewMinhash(1, 256)
+	all := NewMinhash(1, 256)
+	hashing(m1, 0, 600, d)
+	hashing(m2, 400, 1000, d)
+	hashing(all, 0, 1000, d)
+	merged, err := MergeSignatures(m1.Signature(), m2.Signature())
+	if err != nil {
+		t.Fatal(err)
+	}
+	size := MergedSize(m1.Signature(), m2.Signature(), 600, 600)
+	if math.Abs(float64(size)-1000) > 100 {
+		t.Errorf("Estimated merged size %d, actual 1000", size)
+	}
+	if err := m1.Merge(m2); err != nil {
+		t.Fatal(err)
+	}
+	for i, v := range all.Signature() {
+		if m1.Signature()[i] != v || merged[i] != v {
+			t.Fatal("Merged signature is different from the signature of the union")
+		}
+	}
+	if err := m1.Merge(NewMinhash(2, 256)); err == nil {
+		t.Error("Merging MinHash with different seeds should fail")
+	}
+	if err := m1.Merge(NewMinhash(1, 128)); err == nil {
+		t.Error("Merging MinHash with different numHash should fail")
+	}
+	if _, err := MergeSignatures(m1.Signature(), m1.Signature()[:128]); err == nil {
+		t.Error("Merging signatures of different lengths should fail")
+	}
+}
+
 func data(size int) [][]byte {
 	d := make([][]byte, size)
 	for i := range d {
@@ -0,0 +1,22 @@
+//go:build !(linux || darwin || freebsd || netbsd || openbsd || dragonfly)
+
+package lshensemble
+
+import (
+	"io"
+	"os"
+)
+
+// mmapFile falls back to reading the whole file into memory on platforms
+// without mmap support.
+func mmapFile(f *os.File, size int) ([]byte, error) {
+	data := make([]byte, size)
+	if _, err := io.ReadFull(f, data); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+func munmapFile(data []byte) error {
+	return nil
+}
@@ -0,0 +1,262 @@
+package lshensemble
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+
+	"golang.org/x/text/cases"
+	"golang.org/x/text/unicode/norm"
+)
+
+// Normalizer flags in the index file.
+const (
+	normalizeNFKC byte = 1 << iota
+	normalizeCaseFold
+	normalizeTrimSpace
+	normalizeNumbers
+)
+
+var (
+	normalizeFuncsMu sync.RWMutex
+	normalizeFuncs   = make(map[string]func(string) string)
+)
+
+// RegisterNormalizeFunc registers a custom normalization function under
+// a name, so it can be used by Normalizer.Custom. Functions should be
+// registered before an index using them is read, typically in init.
+func RegisterNormalizeFunc(name string, f func(string) string) {
+	normalizeFuncsMu.Lock()
+	defer normalizeFuncsMu.Unlock()
+	normalizeFuncs[name] = f
+}
+
+func lookupNormalizeFunc(name string) (func(string) string, bool) {
+	normalizeFuncsMu.RLock()
+	defer normalizeFuncsMu.RUnlock()
+	f, exists := normalizeFuncs[name]
+	return f, exists
+}
+
+// Normalizer configures how values are normalized before hashing, so
+// values that differ only in representation get the same hash values.
+// The steps are applied in the order of the fields.
+// A nil Normalizer leaves the values unchanged.
+type Normalizer struct {
+	// NFKC applies the Unicode NFKC normalization.
+	NFKC bool
+	// CaseFold applies Unicode case folding.
+	CaseFold bool
+	// TrimSpace removes leading and trailing white space.
+	TrimSpace bool
+	// CanonicalNumbers rewrites decimal numbers in their shortest form,
+	// for example "01", "1.0" and "+1e0" all become "1". Distinct numbers
+	// keep distinct forms however many digits they have.
+	CanonicalNumbers bool
+	// Custom is the name of a function registered using
+	// RegisterNormalizeFunc, applied last. It is empty if there is none.
+	Custom string
+}
+
+// Normalization makes the index remember the normalization of the
+// values of its domains, which is saved by WriteTo, so the queries can be
+// normalized the same way using the Normalizer of the index.
+func Normalization(n Normalizer) Option {
+	return func(o *options) {
+		o.normalizer = &n
+	}
+}
+
+// Normalizer returns the normalization of the values of the indexed
+// domains, nil if the index is created without the Normalization option.
+func (e *LshEnsemble[K]) Normalizer() *Normalizer {
+	return e.normalizer
+}
+
+// Normalize returns the normalized value.
+// It panics if the custom function is not registered, see Validate.
+func (n *Normalizer) Normalize(v []byte) []byte {
+	if n == nil || *n == (Normalizer{}) {
+		return v
+	}
+	s := string(v)
+	if n.NFKC {
+		s = norm.NFKC.String(s)
+	}
+	if n.CaseFold {
+		s = cases.Fold().String(s)
+	}
+	if n.TrimSpace {
+		s = strings.TrimSpace(s)
+	}
+	if n.CanonicalNumbers {
+		s = canonicalNumber(s)
+	}
+	if n.Custom != "" {
+		f, exists := lookupNormalizeFunc(n.Custom)
+		if !exists {
+			panic(fmt.Sprintf("Normalize function %q is not registered", n.Custom))
+		}
+		s = f(s)
+	}
+	return []byte(s)
+}
+
+// maxExponentDigits bounds the exponents of the numbers canonicalized by
+// canonicalNumber, so the decimal point position cannot overflow.
+const maxExponentDigits = 9
+
+// Validate returns an error if the custom function is not registered.
+func (n *Normalizer) Validate() error {
+	if n == nil || n.Custom == "" {
+		return nil
+	}
+	if _, exists := lookupNormalizeFunc(n.Custom); !exists {
+		return fmt.Errorf("Normalize function %q is not registered", n.Custom)
+	}
+	return nil
+}
+
+// canonicalNumber returns the shortest form of s if it is a decimal number,
+// otherwise s is returned unchanged. The digits are rewritten as text, so
+// distinct numbers never get the same form. The form follows
+// strconv.FormatFloat with format 'g' and the smallest precision, except
+// that integers of up to 15 digits are never written with an exponent.
+func canonicalNumber(s string) string {
+	i := 0
+	var neg bool
+	if i < len(s) && (s[i] == '+' || s[i] == '-') {
+		neg = s[i] == '-'
+		i++
+	}
+	// The value is 0.digits * 10^dp.
+	var digits []byte
+	var dp int
+	var sawDigit, sawPoint bool
+mantissa:
+	for ; i < len(s); i++ {
+		switch c := s[i]; {
+		case c >= '0' && c <= '9':
+			sawDigit = true
+			if len(digits) == 0 && c == '0' {
+				// Leading zeros.
+				if sawPoint {
+					dp--
+				}
+				continue
+			}
+			digits = append(digits, c)
+			if !sawPoint {
+				dp++
+			}
+		case c == '.' && !sawPoint:
+			sawPoint = true
+		default:
+			break mantissa
+		}
+	}
+	if !sawDigit {
+		return s
+	}
+	if i < len(s) {
+		if s[i] != 'e' && s[i] != 'E' {
+			return s
+		}
+		i++
+		var expNeg bool
+		if i < len(s) && (s[i] == '+' || s[i] == '-') {
+			expNeg = s[i] == '-'
+			i++
+		}
+		exp := s[i:]
+		if exp == "" || strings.Trim(exp, "0123456789") != "" {
+			return s
+		}
+		// Larger exponents cannot be represented exactly.
+		exp = strings.TrimLeft(exp, "0")
+		if len(exp) > maxExponentDigits {
+			return s
+		}
+		e, _ := strconv.Atoi("0" + exp)
+		if expNeg {
+			e = -e
+		}
+		dp += e
+	}
+	digits = []byte(strings.TrimRight(string(digits), "0"))
+	if len(digits) == 0 {
+		return "0"
+	}
+	var b strings.Builder
+	if neg {
+		b.WriteByte('-')
+	}
+	nd := len(digits)
+	switch exp := dp - 1; {
+	case nd <= dp && dp <= 15:
+		b.Write(digits)
+		b.WriteString(strings.Repeat("0", dp-nd))
+	case exp < -4 || exp >= 6:
+		b.WriteByte(digits[0])
+		if nd > 1 {
+			b.WriteByte('.')
+			b.Write(digits[1:])
+		}
+		b.WriteByte('e')
+		if exp < 0 {
+			b.WriteByte('-')
+			exp = -exp
+		} else {
+			b.WriteByte('+')
+		}
+		if exp < 10 {
+			b.WriteByte('0')
+		}
+		b.WriteString(strconv.Itoa(exp))
+	case dp <= 0:
+		b.WriteString("0.")
+		b.WriteString(strings.Repeat("0", -dp))
+		b.Write(digits)
+	default:
+		b.Write(digits[:dp])
+		b.WriteByte('.')
+		b.Write(digits[dp:])
+	}
+	return b.String()
+}
+
+// flags returns the normalization steps as flags for the index file.
+func (n *Normalizer) flags() byte {
+	var flags byte
+	for _, step := range []struct {
+		enabled bool
+		flag    byte
+	}{
+		{n.NFKC, normalizeNFKC},
+		{n.CaseFold, normalizeCaseFold},
+		{n.TrimSpace, normalizeTrimSpace},
+		{n.CanonicalNumbers, normalizeNumbers},
+	} {
+		if step.enabled {
+			flags |= step.flag
+		}
+	}
+	return flags
+}
+
+// newNormalizer creates a Normalizer from the flags and custom function
+// name in the index file.
+func newNormalizer(flags byte, custom string) (*Normalizer, error) {
+	n := &Normalizer{
+		NFKC:             flags&normalizeNFKC != 0,
+		CaseFold:         flags&normalizeCaseFold != 0,
+		TrimSpace:        flags&normalizeTrimSpace != 0,
+		CanonicalNumbers: flags&normalizeNumbers != 0,
+		Custom:           custom,
+	}
+	if err := n.Validate(); err != nil {
+		return nil, err
+	}
+	return n, nil
+}
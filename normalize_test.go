@@ -0,0 +1,115 @@
+package lshensemble
+
+import (
+	"bytes"
+	"context"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func Test_Normalizer(t *testing.T) {
+	RegisterNormalizeFunc("test-strip-dashes", func(s string) string {
+		return strings.ReplaceAll(s, "-", "")
+	})
+	n := &Normalizer{
+		NFKC:             true,
+		CaseFold:         true,
+		TrimSpace:        true,
+		CanonicalNumbers: true,
+		Custom:           "test-strip-dashes",
+	}
+	for _, c := range []struct{ in, out string }{
+		{"  Hello ", "hello"},
+		{"ＡＢＣ", "abc"},
+		{"Straße", "strasse"},
+		{"01", "1"},
+		{"+1.0e0", "1"},
+		{"2.50", "2.5"},
+		{"1e20", "1e+20"},
+		{"+0.00", "0"},
+		{"1234567.5", "1.2345675e+06"},
+		{"123456789012345", "123456789012345"},
+		{"12345678901234567890", "1.234567890123456789e+19"},
+		{"12345678901234567891", "1.2345678901234567891e+19"},
+		{"9007199254740993", "9.007199254740993e+15"},
+		{"1e99999999999", "1e99999999999"},
+		{"inf", "inf"},
+		{"0x10", "0x10"},
+		{"a-b-c", "abc"},
+	} {
+		if out := string(n.Normalize([]byte(c.in))); out != c.out {
+			t.Errorf("Normalized %q to %q, expecting %q", c.in, out, c.out)
+		}
+	}
+	// The custom function above strips the minus signs.
+	if out := canonicalNumber("-0.000012"); out != "-1.2e-05" {
+		t.Errorf("Canonical form of -0.000012 is %q, expecting -1.2e-05", out)
+	}
+	var none *Normalizer
+	if out := string(none.Normalize([]byte(" A "))); out != " A " {
+		t.Errorf("Nil normalizer changed the value to %q", out)
+	}
+}
+
+func Test_NormalizerSaved(t *testing.T) {
+	RegisterNormalizeFunc("test-identity", func(s string) string { return s })
+	n := Normalizer{CaseFold: true, TrimSpace: true, Custom: "test-identity"}
+	index := NewLshEnsemble[string]([]Partition{{Lower: 1, Upper: 10}}, 16, 4, 10,
+		Normalization(n))
+	var buf bytes.Buffer
+	if _, err := index.WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+	loaded, err := ReadLshEnsemble[string](bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded.Normalizer() == nil || *loaded.Normalizer() != n {
+		t.Fatalf("Loaded normalizer %+v, expecting %+v", loaded.Normalizer(), n)
+	}
+
+	// Reading an index with an unregistered custom function fails.
+	n.Custom = "test-unregistered"
+	RegisterNormalizeFunc(n.Custom, strings.ToLower)
+	index = NewLshEnsemble[string]([]Partition{{Lower: 1, Upper: 10}}, 16, 4, 10,
+		Normalization(n))
+	buf.Reset()
+	if _, err := index.WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	normalizeFuncsMu.Lock()
+	delete(normalizeFuncs, n.Custom)
+	normalizeFuncsMu.Unlock()
+	if _, err := ReadLshEnsemble[string](&buf); err == nil {
+		t.Error("Reading an index with an unregistered normalize function should fail")
+	}
+}
+
+func Test_SignatureBuilderNormalizer(t *testing.T) {
+	b := NewSignatureBuilder[string](1, 32, 1)
+	b.Normalizer = &Normalizer{CaseFold: true, TrimSpace: true}
+	in := make(chan RawDomain[string], 2)
+	in <- RawDomain[string]{"a", slices.Values([][]byte{[]byte("X"), []byte(" x ")})}
+	in <- RawDomain[string]{"b", slices.Values([][]byte{[]byte("x")})}
+	close(in)
+	recs := make(map[string]*DomainRecord[string])
+	out, err := b.Build(context.Background(), in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for rec := range out {
+		recs[rec.Key] = rec
+	}
+	if recs["a"].Size != 1 {
+		t.Errorf("Normalized domain has size %d, expecting 1", recs["a"].Size)
+	}
+	if !slices.Equal(recs["a"].Signature, recs["b"].Signature) {
+		t.Error("Normalized domains should have the same signature")
+	}
+	b.Normalizer = &Normalizer{Custom: "test-missing"}
+	if _, err := b.Build(context.Background(), in); err == nil {
+		t.Error("Building with an unregistered normalize function should fail")
+	}
+}
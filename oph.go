@@ -0,0 +1,118 @@
+package lshensemble
+
+import (
+	"encoding/binary"
+	"hash"
+	"hash/fnv"
+	"math"
+	"math/bits"
+	"math/rand"
+)
+
+// Sketch is implemented by Minhash and OnePermutationMinhash. The signatures
+// of both can be used with Containment and indexed by LshEnsemble, but
+// signatures from different kinds of sketches must not be mixed in an index.
+type Sketch interface {
+	// Push a new value to the sketch.
+	Push(b []byte)
+	// Signature exports the signature.
+	Signature() []uint64
+}
+
+var (
+	_ Sketch = (*Minhash)(nil)
+	_ Sketch = (*OnePermutationMinhash)(nil)
+)
+
+// OnePermutationMinhash represents a one permutation hashing sketch with
+// optimal densification
+// (http://proceedings.mlr.press/v70/shrivastava17a/shrivastava17a.pdf).
+// Unlike Minhash, it computes a single hash value for every pushed value,
+// which falls into one of the numHash bins, and the signature is the
+// minimum hash value of every bin. Empty bins are filled by borrowing the
+// values of randomly chosen non-empty bins.
+type OnePermutationMinhash struct {
+	// initSeed is the seed given to NewOnePermutationMinhash.
+	initSeed int64
+	seed     uint64
+	salt     []byte
+	h        hash.Hash64
+	bins     []uint64
+	// filled marks the bins with at least one value.
+	filled []bool
+}
+
+// NewOnePermutationMinhash initializes a one permutation hashing sketch with
+// a seed and the number of hash values in the signature.
+func NewOnePermutationMinhash(seed int64, numHash int) *OnePermutationMinhash {
+	r := rand.New(rand.NewSource(seed))
+	salt := make([]byte, HashValueSize)
+	binary.BigEndian.PutUint64(salt, uint64(r.Int63()))
+	bins := make([]uint64, numHash)
+	for i := range bins {
+		bins[i] = math.MaxUint64
+	}
+	return &OnePermutationMinhash{
+		initSeed: seed,
+		seed:     uint64(r.Int63()),
+		salt:     salt,
+		h:        fnv.New64a(),
+		bins:     bins,
+		filled:   make([]bool, numHash),
+	}
+}
+
+// Push a new value to the sketch.
+// The value should be serialized to byte slice.
+func (m *OnePermutationMinhash) Push(b []byte) {
+	m.h.Reset()
+	m.h.Write(m.salt)
+	m.h.Write(b)
+	v := m.h.Sum64()
+	// The high bits of the product choose the bin uniformly.
+	bin, _ := bits.Mul64(v, uint64(len(m.bins)))
+	if v < m.bins[bin] {
+		m.bins[bin] = v
+	}
+	m.filled[bin] = true
+}
+
+// Signature exports the signature, with empty bins densified.
+func (m *OnePermutationMinhash) Signature() []uint64 {
+	sig := make([]uint64, len(m.bins))
+	copy(sig, m.bins)
+	var numFilled int
+	for _, f := range m.filled {
+		if f {
+			numFilled++
+		}
+	}
+	if numFilled == 0 || numFilled == len(m.bins) {
+		return sig
+	}
+	n := uint64(len(m.bins))
+	for i := range sig {
+		if m.filled[i] {
+			continue
+		}
+		// Probe with a hash of the bin index and the attempt number, so
+		// two sketches borrow from the same bins given the same seed.
+		for attempt := uint64(1); ; attempt++ {
+			j, _ := bits.Mul64(splitMix64(m.seed^uint64(i)<<32^attempt), n)
+			if m.filled[j] {
+				sig[i] = m.bins[j]
+				break
+			}
+		}
+	}
+	return sig
+}
+
+// splitMix64 is the finalizer of the SplitMix64 generator, used as a
+// fast universal hash for densification.
+func splitMix64(x uint64) uint64 {
+	x += 0x9e3779b97f4a7c15
+	x = (x ^ (x >> 30)) * 0xbf58476d1ce4e5b9
+	x = (x ^ (x >> 27)) * 0x94d049bb133111eb
+	return x ^ (x >> 31)
+}
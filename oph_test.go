@@ -0,0 +1,41 @@
+package lshensemble
+
+import (
+	"math"
+	"testing"
+)
+
+func Test_OnePermutationMinhash(t *testing.T) {
+	d := data(2000)
+	m1 := NewOnePermutationMinhash(1, 256)
+	m2 := NewOnePermutationMinhash(1, 256)
+	// m1 has the first 1500 values and m2 has the last 1500 values.
+	push := func(m Sketch, start, end int) {
+		for i := start; i < end; i++ {
+			m.Push(d[i])
+		}
+	}
+	push(m1, 0, 1500)
+	push(m2, 500, 2000)
+	sig1, sig2 := m1.Signature(), m2.Signature()
+	var eq int
+	for i := range sig1 {
+		if sig1[i] == sig2[i] {
+			eq++
+		}
+	}
+	est := float64(eq) / float64(len(sig1))
+	act := 1000.0 / 2000.0
+	if math.Abs(est-act) > 0.1 {
+		t.Fatalf("Estimated Jaccard %f, actual %f", est, act)
+	}
+
+	// Densification must fill all empty bins of a small domain.
+	m3 := NewOnePermutationMinhash(1, 256)
+	push(m3, 0, 10)
+	for _, v := range m3.Signature() {
+		if v == math.MaxUint64 {
+			t.Fatal("Empty bin was not densified")
+		}
+	}
+}
@@ -12,23 +12,34 @@ func integral(f func(float64) float64, a, b, precision float64) float64 {
 	return area
 }
 
+// The probability of two hash values of b bits being equal given the
+// Jaccard similarity j, which includes the accidental collisions of
+// hash values with probability 2^-b.
+func collisionProbability(j float64, b int) float64 {
+	c := math.Pow(2.0, -float64(b))
+	return c + (1.0-c)*j
+}
+
 // Probability density function for false positive
-func falsePositive(x, q, l, k int) func(float64) float64 {
+func falsePositive(x, q, l, k, b int) func(float64) float64 {
 	return func(t float64) float64 {
-		return 1.0 - math.Pow(1.0-math.Pow(t/(1.0+float64(x)/float64(q)-t), float64(k)), float64(l))
+		j := collisionProbability(t/(1.0+float64(x)/float64(q)-t), b)
+		return 1.0 - math.Pow(1.0-math.Pow(j, float64(k)), float64(l))
 	}
 }
 
 // Probability density function for false negative
-func falseNegative(x, q, l, k int) func(float64) float64 {
+func falseNegative(x, q, l, k, b int) func(float64) float64 {
 	return func(t float64) float64 {
-		return 1.0 - (1.0 - math.Pow(1.0-math.Pow(t/(1.0+float64(x)/float64(q)-t), float64(k)), float64(l)))
+		j := collisionProbability(t/(1.0+float64(x)/float64(q)-t), b)
+		return 1.0 - (1.0 - math.Pow(1.0-math.Pow(j, float64(k)), float64(l)))
 	}
 }
 
-// Compute the cummulative probability of false negative
-func probFalseNegative(x, q, l, k int, t, precision float64) float64 {
-	fn := falseNegative(x, q, l, k)
+// Compute the cummulative probability of false negative,
+// b is the number of bits of hash values.
+func probFalseNegative(x, q, l, k, b int, t, precision float64) float64 {
+	fn := falseNegative(x, q, l, k, b)
 	xq := float64(x) / float64(q)
 	if xq >= 1.0 {
 		return integral(fn, t, 1.0, precision)
@@ -40,9 +51,10 @@ func probFalseNegative(x, q, l, k int, t, precision float64) float64 {
 	}
 }
 
-// Compute the cummulative probability of false positive
-func probFalsePositive(x, q, l, k int, t, precision float64) float64 {
-	fp := falsePositive(x, q, l, k)
+// Compute the cummulative probability of false positive,
+// b is the number of bits of hash values.
+func probFalsePositive(x, q, l, k, b int, t, precision float64) float64 {
+	fp := falsePositive(x, q, l, k, b)
 	xq := float64(x) / float64(q)
 	if xq >= 1.0 {
 		return integral(fp, 0.0, t, precision)
@@ -53,3 +65,28 @@ func probFalsePositive(x, q, l, k int, t, precision float64) float64 {
 		return integral(fp, 0.0, xq, precision)
 	}
 }
+
+// Search for the K and L that minimize the sum of the false positive and
+// false negative probabilities, subject to 1 <= K <= maxK, 1 <= L <= maxL
+// and K * L <= numHash. b is the number of bits of hash values.
+func optimalKL(maxK, maxL, numHash, b, x, q int, t float64) (optK, optL int, fp, fn float64) {
+	minError := math.MaxFloat64
+	for l := 1; l <= maxL; l++ {
+		for k := 1; k <= maxK; k++ {
+			if k*l > numHash {
+				continue
+			}
+			currFp := probFalsePositive(x, q, l, k, b, t, integrationPrecision)
+			currFn := probFalseNegative(x, q, l, k, b, t, integrationPrecision)
+			currErr := currFn + currFp
+			if minError > currErr {
+				minError = currErr
+				optK = k
+				optL = l
+				fp = currFp
+				fn = currFn
+			}
+		}
+	}
+	return
+}
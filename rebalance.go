@@ -0,0 +1,161 @@
+package lshensemble
+
+import (
+	"errors"
+	"sort"
+)
+
+var errRebalanceDomains = errors.New("Rebalancing requires the KeepDomains option")
+
+// RebalancePlan describes the optimal partitions for the current domain
+// size distribution of an index, which are applied by Commit.
+type RebalancePlan[K comparable] struct {
+	// Partitions are the new partitions.
+	Partitions []Partition
+	// CurrentFalsePositives and FalsePositives are the expected numbers of
+	// false positives caused by using the upper bounds of the partitions as
+	// the domain sizes, which is what the optimal partitioning minimizes,
+	// for the current and the new partitions.
+	CurrentFalsePositives float64
+	FalsePositives        float64
+	e                     *LshEnsemble[K]
+}
+
+// Improvement returns the relative reduction of the expected number of
+// false positives by the new partitions.
+func (p *RebalancePlan[K]) Improvement() float64 {
+	if p.CurrentFalsePositives == 0 {
+		return 0
+	}
+	return 1 - p.FalsePositives/p.CurrentFalsePositives
+}
+
+// PlanRebalance computes the optimal numPart partitions for the sizes of
+// the domains in the index, numPart <= 0 keeps the current number of
+// partitions. The index must keep domains, see KeepDomains. Domains added
+// using Add have no known sizes, so they are not counted, and are moved to
+// the new partition of the upper bound of their current partition.
+// Nothing is changed until the plan is committed.
+func (e *LshEnsemble[K]) PlanRebalance(numPart int) (*RebalancePlan[K], error) {
+	if e.domains == nil {
+		return nil, errRebalanceDomains
+	}
+	if e.readOnly || e.newLsh == nil {
+		return nil, errReadOnly
+	}
+	parts, _ := e.layout()
+	if numPart <= 0 {
+		numPart = len(parts)
+	}
+	sizes, counts := e.domains.sizeDistribution()
+	if len(sizes) == 0 {
+		return nil, errors.New("Index has no domains of known sizes to rebalance")
+	}
+	newParts := optimalPartitions(sizes, counts, numPart)
+	return &RebalancePlan[K]{
+		Partitions:            newParts,
+		CurrentFalsePositives: expectedFalsePositives(parts, sizes, counts),
+		FalsePositives:        expectedFalsePositives(newParts, sizes, counts),
+		e:                     e,
+	}, nil
+}
+
+// Commit moves the domains into new Lsh of the planned partitions, and
+// replaces the partitions of the index with them atomically. Domains added
+// but not indexed become searchable, and the partitions are extended to
+// the sizes of domains added since the plan was made.
+// Queries keep searching the current partitions until they are replaced,
+// while adding, removing and indexing domains wait for the commit.
+func (p *RebalancePlan[K]) Commit() {
+	e := p.e
+	e.writeMu.Lock()
+	defer e.writeMu.Unlock()
+	parts := make([]Partition, len(p.Partitions))
+	copy(parts, p.Partitions)
+	e.layoutMu.RLock()
+	prefixDirKs := e.prefixDirKs
+	e.layoutMu.RUnlock()
+
+	s := e.domains
+	s.mu.RLock()
+	last := len(parts) - 1
+	for _, d := range s.domains {
+		if !d.exact {
+			continue
+		}
+		parts[0].Lower = min(parts[0].Lower, d.size)
+		parts[last].Upper = max(parts[last].Upper, d.size)
+	}
+	lshes := make([]Lsh[K], len(parts))
+	for i := range parts {
+		lshes[i] = e.newLsh(parts[i].Upper)
+		enablePrefixDirectory(lshes[i], prefixDirKs)
+	}
+	for key, d := range s.domains {
+		sig := d.sig
+		if d.packed != nil {
+			sig = UnpackSignature(d.packed, s.hashValueBits, e.numHash)
+		}
+		lshes[partitionOf(parts, d.size)].Add(key, sig)
+	}
+	s.mu.RUnlock()
+	for _, lsh := range lshes {
+		lsh.Index()
+	}
+
+	e.layoutMu.Lock()
+	e.Partitions, e.lshes = parts, lshes
+	e.overflow = false
+	e.layoutMu.Unlock()
+	e.paramCache.Clear()
+}
+
+// sizeDistribution returns the distinct sizes of the domains of known
+// sizes in ascending order, and the number of domains of every size.
+func (s *domainStore[K]) sizeDistribution() (sizes, counts []int) {
+	s.mu.RLock()
+	histogram := make(map[int]int)
+	for _, d := range s.domains {
+		if d.exact {
+			histogram[d.size]++
+		}
+	}
+	s.mu.RUnlock()
+	sizes = make([]int, 0, len(histogram))
+	for size := range histogram {
+		sizes = append(sizes, size)
+	}
+	sort.Ints(sizes)
+	counts = make([]int, len(sizes))
+	for i, size := range sizes {
+		counts[i] = histogram[size]
+	}
+	return sizes, counts
+}
+
+// partitionOf returns the index of the first partition whose upper bound
+// is no less than the size, or the last partition if there is none.
+func partitionOf(parts []Partition, size int) int {
+	for i, p := range parts {
+		if size <= p.Upper {
+			return i
+		}
+	}
+	return len(parts) - 1
+}
+
+// expectedFalsePositives computes the expected number of false positives
+// caused by the partitions for the domain size distribution, see computeNFP.
+func expectedFalsePositives(parts []Partition, sizes, counts []int) float64 {
+	if len(parts) == 0 {
+		return 0
+	}
+	var nfp float64
+	for i, size := range sizes {
+		upper := parts[partitionOf(parts, size)].Upper
+		if size < upper {
+			nfp += float64(upper-size) / float64(upper) * float64(counts[i])
+		}
+	}
+	return nfp
+}
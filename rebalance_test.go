@@ -0,0 +1,114 @@
+package lshensemble
+
+import (
+	"testing"
+)
+
+func Test_LshEnsembleRebalance(t *testing.T) {
+	recs := testDomainRecords(200, 64)
+	parts := []Partition{{Lower: 1, Upper: 2}, {Lower: 3, Upper: 20}}
+	index := NewLshEnsemble[string](parts, 64, 4, 0, KeepDomains())
+	for _, rec := range recs {
+		if err := index.Prepare(rec.Key, rec.Signature, rec.Size); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// A domain without size is moved but not counted.
+	unsized := recs[len(recs)-1]
+	if err := index.Add("unsized", unsized.Signature, 1); err != nil {
+		t.Fatal(err)
+	}
+	index.Index()
+	plan, err := index.PlanRebalance(4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(plan.Partitions) != 4 {
+		t.Fatalf("Planned %d partitions, expecting 4", len(plan.Partitions))
+	}
+	if plan.FalsePositives >= plan.CurrentFalsePositives || plan.Improvement() <= 0 {
+		t.Fatalf("Expected false positives %f, currently %f",
+			plan.FalsePositives, plan.CurrentFalsePositives)
+	}
+	if index.Partitions[1] != parts[1] {
+		t.Fatal("Partitions changed before the plan is committed")
+	}
+	// Domains added after the plan are moved as well.
+	large := testDomainRecords(1, 64)[0]
+	large.Key = "large"
+	if err := index.Update(large.Key, large.Signature, 30); err == nil {
+		t.Fatal("Size out of range should be rejected")
+	}
+	if err := index.Prepare(large.Key, large.Signature, 20); err != nil {
+		t.Fatal(err)
+	}
+	plan.Commit()
+	for i, p := range index.Partitions {
+		if p != plan.Partitions[i] {
+			t.Errorf("Partition %d is %v, expecting %v", i, p, plan.Partitions[i])
+		}
+	}
+	var numKeys int
+	for _, plan := range index.Explain(1, 0.5) {
+		numKeys += plan.NumDomains
+	}
+	if numKeys != len(recs)+2 {
+		t.Fatalf("Rebalanced index has %d domains, expecting %d", numKeys, len(recs)+2)
+	}
+	if !containsKey(t, index, &DomainRecord[string]{Key: "unsized", Size: unsized.Size, Signature: unsized.Signature}) {
+		t.Fatal("Unable to retrieve the domain without size")
+	}
+	for _, rec := range recs {
+		if !containsKey(t, index, rec) {
+			t.Fatalf("Unable to retrieve key %s", rec.Key)
+		}
+	}
+
+	index = NewLshEnsemble[string](parts, 64, 4, 0)
+	if _, err := index.PlanRebalance(4); err == nil {
+		t.Error("Rebalancing without kept domains should fail")
+	}
+}
+
+func Test_LshEnsembleRebalanceExtend(t *testing.T) {
+	recs := testDomainRecords(200, 64)
+	parts := []Partition{{Lower: 1, Upper: 2}, {Lower: 3, Upper: 20}}
+	index := NewLshEnsemble[string](parts, 64, 4, 0, KeepDomains(), Routing(ExtendPartitions))
+	for _, rec := range recs {
+		if err := index.Prepare(rec.Key, rec.Signature, rec.Size); err != nil {
+			t.Fatal(err)
+		}
+	}
+	plan, err := index.PlanRebalance(4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	last := len(plan.Partitions) - 1
+	if plan.Partitions[last].Upper != 20 {
+		t.Fatalf("Planned partitions %v, expecting the upper bound 20", plan.Partitions)
+	}
+	// A domain added after the plan with a size out of the planned
+	// partitions extends the last of them.
+	large := &DomainRecord[string]{Key: "large", Size: 30, Signature: recs[0].Signature}
+	if err := index.Prepare(large.Key, large.Signature, large.Size); err != nil {
+		t.Fatal(err)
+	}
+	plan.Commit()
+	for i, p := range index.Partitions {
+		expected := plan.Partitions[i]
+		if i == last {
+			expected.Upper = 30
+		}
+		if p != expected {
+			t.Errorf("Partition %d is %v, expecting %v", i, p, expected)
+		}
+	}
+	if !containsKey(t, index, large) {
+		t.Fatal("Unable to retrieve the domain added after the plan")
+	}
+	for _, rec := range recs {
+		if !containsKey(t, index, rec) {
+			t.Fatalf("Unable to retrieve key %s", rec.Key)
+		}
+	}
+}
@@ -0,0 +1,119 @@
+package lshensemble
+
+import (
+	"errors"
+	"fmt"
+)
+
+// RoutingPolicy decides how Prepare and Update route domains whose sizes
+// are out of the range of the partitions.
+// Sizes in a gap between two partitions always go to the partition above
+// the gap, whose lower bound is extended to the size.
+type RoutingPolicy int
+
+const (
+	// RejectOutOfRange rejects the domains with a *SizeOutOfRangeError.
+	// It is the default policy.
+	RejectOutOfRange RoutingPolicy = iota
+	// ExtendPartitions extends the lower bound of the first partition or
+	// the upper bound of the last partition to the size.
+	ExtendPartitions
+	// OverflowPartition adds the domains larger than the last partition to
+	// an overflow partition, which is created by the first of them and
+	// extended to the size of the largest. Smaller domains extend the
+	// first partition, as the partition of the smallest domains has
+	// little effect on the false positives.
+	OverflowPartition
+)
+
+// Routing sets the policy for routing domains with sizes out of the range
+// of the partitions. The policy is saved by WriteTo, together with whether
+// the last partition is an overflow partition.
+func Routing(p RoutingPolicy) Option {
+	return func(o *options) {
+		o.routing = p
+	}
+}
+
+// SizeOutOfRangeError is returned when a domain size is out of the range
+// of the partitions, and the routing policy is RejectOutOfRange.
+type SizeOutOfRangeError struct {
+	// Size is the domain size.
+	Size int
+	// Lower and Upper are the bounds of all partitions.
+	Lower, Upper int
+}
+
+func (err *SizeOutOfRangeError) Error() string {
+	return fmt.Sprintf("Domain size %d is out of the range [%d, %d] of the partitions",
+		err.Size, err.Lower, err.Upper)
+}
+
+// findPartition returns the index of the partition containing the size.
+func findPartition(parts []Partition, size int) (int, bool) {
+	for i, p := range parts {
+		if size >= p.Lower && size <= p.Upper {
+			return i, true
+		}
+	}
+	return -1, false
+}
+
+// route returns the index of the partition of a domain with the given
+// size, extending or adding partitions as the routing policy allows.
+func (e *LshEnsemble[K]) route(size int) (int, error) {
+	parts, _ := e.layout()
+	if i, ok := findPartition(parts, size); ok {
+		return i, nil
+	}
+	e.layoutMu.Lock()
+	defer e.layoutMu.Unlock()
+	// The partitions may have changed since they were read.
+	parts = e.Partitions
+	if i, ok := findPartition(parts, size); ok {
+		return i, nil
+	}
+	if len(parts) == 0 {
+		return -1, errors.New("Index has no partitions")
+	}
+	first, last := 0, len(parts)-1
+	inRange := size >= parts[first].Lower && size <= parts[last].Upper
+	if !inRange && e.routing == RejectOutOfRange {
+		return -1, &SizeOutOfRangeError{size, parts[first].Lower, parts[last].Upper}
+	}
+	// Partitions are replaced rather than modified, as they may be read by
+	// queries without holding the lock.
+	extended := make([]Partition, len(parts), len(parts)+1)
+	copy(extended, parts)
+	var i int
+	switch {
+	case inRange:
+		// Go to the partition above the gap.
+		for i = range parts {
+			if size < parts[i].Lower {
+				break
+			}
+		}
+		extended[i].Lower = size
+	case size < parts[first].Lower:
+		i = first
+		extended[i].Lower = size
+	case e.routing == ExtendPartitions || e.overflow:
+		i = last
+		extended[i].Upper = size
+	default:
+		if e.newLsh == nil {
+			return -1, errReadOnly
+		}
+		lsh := e.newLsh(size)
+		enablePrefixDirectory(lsh, e.prefixDirKs)
+		i = len(parts)
+		extended = append(extended, Partition{Lower: parts[last].Upper + 1, Upper: size})
+		lshes := make([]Lsh[K], len(e.lshes), len(e.lshes)+1)
+		copy(lshes, e.lshes)
+		e.lshes = append(lshes, lsh)
+		e.overflow = true
+	}
+	e.Partitions = extended
+	return i, nil
+}
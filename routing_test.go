@@ -0,0 +1,147 @@
+package lshensemble
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+)
+
+func Test_LshEnsemblePrepare(t *testing.T) {
+	recs := testDomainRecords(100, 64)
+	parts := []Partition{{Lower: 1, Upper: 10}, {Lower: 11, Upper: 20}}
+	index := NewLshEnsemble[string](parts, 64, 4, 0)
+	for _, rec := range recs {
+		if err := index.Prepare(rec.Key, rec.Signature, rec.Size); err != nil {
+			t.Fatal(err)
+		}
+	}
+	index.Index()
+	for _, rec := range recs {
+		if !containsKey(t, index, rec) {
+			t.Fatalf("Unable to retrieve key %s", rec.Key)
+		}
+	}
+}
+
+func containsKey(t *testing.T, index *LshEnsemble[string], rec *DomainRecord[string]) bool {
+	result, err := index.QueryContext(context.Background(), rec.Signature, rec.Size, 0.9)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range result {
+		if key == rec.Key {
+			return true
+		}
+	}
+	return false
+}
+
+func Test_LshEnsembleRouting(t *testing.T) {
+	recs := testDomainRecords(100, 64)
+	parts := func() []Partition {
+		return []Partition{{Lower: 5, Upper: 8}, {Lower: 11, Upper: 15}}
+	}
+
+	index := NewLshEnsemble[string](parts(), 64, 4, 0)
+	var rangeErr *SizeOutOfRangeError
+	for _, rec := range recs {
+		err := index.Prepare(rec.Key, rec.Signature, rec.Size)
+		if rec.Size < 5 || rec.Size > 15 {
+			if !errors.As(err, &rangeErr) || rangeErr.Size != rec.Size {
+				t.Fatalf("Size %d returned %v, expecting SizeOutOfRangeError", rec.Size, err)
+			}
+		} else if err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Sizes in the gap extend the partition above.
+	if index.Partitions[1].Lower != 9 {
+		t.Errorf("Partition above the gap has lower bound %d, expecting 9",
+			index.Partitions[1].Lower)
+	}
+
+	index = NewLshEnsemble[string](parts(), 64, 4, 0, Routing(ExtendPartitions))
+	for _, rec := range recs {
+		if err := index.Prepare(rec.Key, rec.Signature, rec.Size); err != nil {
+			t.Fatal(err)
+		}
+	}
+	expected := []Partition{{Lower: 1, Upper: 8}, {Lower: 9, Upper: 20}}
+	for i, p := range index.Partitions {
+		if p != expected[i] {
+			t.Errorf("Partition %d is %v, expecting %v", i, p, expected[i])
+		}
+	}
+
+	index = NewLshEnsemblePlus[string](parts(), 64, 4, 0, Routing(OverflowPartition))
+	for _, rec := range recs {
+		if err := index.Prepare(rec.Key, rec.Signature, rec.Size); err != nil {
+			t.Fatal(err)
+		}
+	}
+	expected = []Partition{{Lower: 1, Upper: 8}, {Lower: 9, Upper: 15}, {Lower: 16, Upper: 20}}
+	if len(index.Partitions) != len(expected) {
+		t.Fatalf("Index has partitions %v, expecting %v", index.Partitions, expected)
+	}
+	for i, p := range index.Partitions {
+		if p != expected[i] {
+			t.Errorf("Partition %d is %v, expecting %v", i, p, expected[i])
+		}
+	}
+	index.Index()
+	for _, rec := range recs {
+		if !containsKey(t, index, rec) {
+			t.Fatalf("Unable to retrieve key %s", rec.Key)
+		}
+	}
+}
+
+func Test_LshEnsembleRoutingWriteRead(t *testing.T) {
+	recs := testDomainRecords(100, 64)
+	for _, c := range []struct {
+		policy   RoutingPolicy
+		expected []Partition
+	}{
+		{ExtendPartitions, []Partition{{Lower: 1, Upper: 8}, {Lower: 9, Upper: 20}}},
+		{OverflowPartition, []Partition{{Lower: 1, Upper: 8}, {Lower: 9, Upper: 15}, {Lower: 16, Upper: 20}}},
+	} {
+		parts := []Partition{{Lower: 5, Upper: 8}, {Lower: 11, Upper: 15}}
+		index := NewLshEnsemble[string](parts, 64, 4, 0, Routing(c.policy))
+		var last *DomainRecord[string]
+		for _, rec := range recs {
+			if rec.Size == 20 {
+				last = rec
+				continue
+			}
+			if err := index.Prepare(rec.Key, rec.Signature, rec.Size); err != nil {
+				t.Fatal(err)
+			}
+		}
+		var buf bytes.Buffer
+		if _, err := index.WriteTo(&buf); err != nil {
+			t.Fatal(err)
+		}
+		loaded, err := ReadLshEnsemble[string](&buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// The size is out of the range of the partitions, and extends the
+		// last partition rather than adding one.
+		if err := loaded.Prepare(last.Key, last.Signature, last.Size); err != nil {
+			t.Fatal(err)
+		}
+		if len(loaded.Partitions) != len(c.expected) {
+			t.Fatalf("Index has partitions %v, expecting %v", loaded.Partitions, c.expected)
+		}
+		for i, p := range loaded.Partitions {
+			if p != c.expected[i] {
+				t.Errorf("Partition %d is %v, expecting %v", i, p, c.expected[i])
+			}
+		}
+		loaded.Index()
+		if !containsKey(t, loaded, last) {
+			t.Fatalf("Unable to retrieve key %s", last.Key)
+		}
+	}
+}
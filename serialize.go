@@ -0,0 +1,667 @@
+package lshensemble
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"math"
+	"slices"
+)
+
+// The on-disk format of an LSH Ensemble index is (all integers are
+// little-endian):
+//
+//	magic "LSHE" | version u32 | numHash u32 | maxK u32 | lsh kind u8 |
+//	hash family u8 | seed i64 |
+//	has normalizer u8 | normalizer flags u8 | custom length u32 | custom |
+//	routing u8 | overflow u8 | size error f64 |
+//	numPart u32 | numPart * (lower i64, upper i64) |
+//	numPart * partition | crc32 u32
+//
+// A partition is a single forest for LshForest, or maxK forests for
+// LshForestArray. A forest is:
+//
+//	k u32 | l u32 | hashValueBits u32 | numEntries u64 | numIndexedKeys u64 |
+//	numKeys u64 | (numKeys+1) * key offset u64 | key blob |
+//	l * (numEntries * hash key | numEntries * key id u32)
+//
+// Every hash key in a forest has the same width, k * hashValueBits rounded
+// up to whole bytes, so the hash tables can be binary-searched in place.
+// The trailing checksum is the CRC-32 (IEEE) of everything before it.
+//
+// The hash family and seed describe the accepted encoded signatures, the
+// hash family is 0 if the index has no signature configuration. The
+// normalizer flags and the name of the custom function describe the
+// normalization of the domain values. The routing policy is a
+// RoutingPolicy, and overflow is 1 if the last partition is an overflow
+// partition. The size error is the relative standard error of estimated
+// domain sizes, see EstimatedSizes, and 0 if the sizes are exact.
+//
+// Version 1 stored the hash value size in bytes instead of bits, versions
+// before 3 had no hash family and seed, versions before 4 had no
+// normalizer, versions before 5 had no routing policy, and versions
+// before 6 had no size error, they are still readable.
+const (
+	indexMagic   = "LSHE"
+	indexVersion = 6
+)
+
+// maxNumHash is the largest number of hash functions of an index that can
+// be saved. It bounds the number of hash tables read from a corrupted
+// file before the checksum is verified.
+const maxNumHash = 1 << 16
+
+// readChunkSize is the largest buffer allocated at once for a section of
+// the index file, so a corrupted length fails at the end of the input
+// instead of allocating the memory it claims.
+const readChunkSize = 1 << 20
+
+const (
+	lshKindForest      byte = 1
+	lshKindForestArray byte = 2
+)
+
+// Key type tags used for encoding keys.
+const (
+	keyTagString byte = iota + 1
+	keyTagInt
+	keyTagInt32
+	keyTagInt64
+	keyTagUint
+	keyTagUint32
+	keyTagUint64
+)
+
+var (
+	errBadMagic    = errors.New("Not an LSH Ensemble index")
+	errBadChecksum = errors.New("Index checksum mismatch")
+)
+
+// countingWriter keeps track of the number of bytes written.
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// binWriter writes little-endian integers and remembers the first error,
+// so the callers only need to check once at the end.
+type binWriter struct {
+	w   io.Writer
+	buf [8]byte
+	err error
+}
+
+func (b *binWriter) bytes(p []byte) {
+	if b.err != nil {
+		return
+	}
+	_, b.err = b.w.Write(p)
+}
+
+func (b *binWriter) u8(v byte) {
+	b.buf[0] = v
+	b.bytes(b.buf[:1])
+}
+
+func (b *binWriter) u32(v uint32) {
+	binary.LittleEndian.PutUint32(b.buf[:4], v)
+	b.bytes(b.buf[:4])
+}
+
+func (b *binWriter) u64(v uint64) {
+	binary.LittleEndian.PutUint64(b.buf[:8], v)
+	b.bytes(b.buf[:8])
+}
+
+// binReader is the reading counterpart of binWriter.
+type binReader struct {
+	r   io.Reader
+	buf [8]byte
+	err error
+}
+
+func (b *binReader) bytes(p []byte) {
+	if b.err != nil {
+		return
+	}
+	_, b.err = io.ReadFull(b.r, p)
+}
+
+// next reads n bytes into a new slice, which grows as the data arrives.
+func (b *binReader) next(n int) []byte {
+	if b.err != nil {
+		return nil
+	}
+	if n < 0 {
+		b.err = errors.New("Invalid section length")
+		return nil
+	}
+	buf := make([]byte, 0, min(n, readChunkSize))
+	for len(buf) < n && b.err == nil {
+		m := min(n-len(buf), readChunkSize)
+		buf = slices.Grow(buf, m)[:len(buf)+m]
+		b.bytes(buf[len(buf)-m:])
+	}
+	return buf
+}
+
+func (b *binReader) u8() byte {
+	b.bytes(b.buf[:1])
+	return b.buf[0]
+}
+
+func (b *binReader) u32() uint32 {
+	b.bytes(b.buf[:4])
+	return binary.LittleEndian.Uint32(b.buf[:4])
+}
+
+func (b *binReader) u64() uint64 {
+	b.bytes(b.buf[:8])
+	return binary.LittleEndian.Uint64(b.buf[:8])
+}
+
+// encodeKey appends the encoding of an index key to dst.
+func encodeKey(dst []byte, key interface{}) ([]byte, error) {
+	var buf [8]byte
+	switch v := key.(type) {
+	case string:
+		dst = append(dst, keyTagString)
+		return append(dst, v...), nil
+	case int:
+		binary.LittleEndian.PutUint64(buf[:], uint64(v))
+		return append(append(dst, keyTagInt), buf[:]...), nil
+	case int32:
+		binary.LittleEndian.PutUint32(buf[:4], uint32(v))
+		return append(append(dst, keyTagInt32), buf[:4]...), nil
+	case int64:
+		binary.LittleEndian.PutUint64(buf[:], uint64(v))
+		return append(append(dst, keyTagInt64), buf[:]...), nil
+	case uint:
+		binary.LittleEndian.PutUint64(buf[:], uint64(v))
+		return append(append(dst, keyTagUint), buf[:]...), nil
+	case uint32:
+		binary.LittleEndian.PutUint32(buf[:4], v)
+		return append(append(dst, keyTagUint32), buf[:4]...), nil
+	case uint64:
+		binary.LittleEndian.PutUint64(buf[:], v)
+		return append(append(dst, keyTagUint64), buf[:]...), nil
+	}
+	return nil, fmt.Errorf("Unsupported key type %T", key)
+}
+
+// decodeKey decodes a key produced by encodeKey.
+func decodeKey(data []byte) (interface{}, error) {
+	if len(data) == 0 {
+		return nil, errors.New("Empty key encoding")
+	}
+	tag, v := data[0], data[1:]
+	switch tag {
+	case keyTagString:
+		return string(v), nil
+	case keyTagInt32, keyTagUint32:
+		if len(v) != 4 {
+			break
+		}
+		u := binary.LittleEndian.Uint32(v)
+		if tag == keyTagInt32 {
+			return int32(u), nil
+		}
+		return u, nil
+	case keyTagInt, keyTagInt64, keyTagUint, keyTagUint64:
+		if len(v) != 8 {
+			break
+		}
+		u := binary.LittleEndian.Uint64(v)
+		switch tag {
+		case keyTagInt:
+			return int(u), nil
+		case keyTagInt64:
+			return int64(u), nil
+		case keyTagUint:
+			return uint(u), nil
+		}
+		return u, nil
+	}
+	return nil, fmt.Errorf("Invalid key encoding with tag %d", tag)
+}
+
+// decodeKeyAs decodes a key and checks it has the key type of the index.
+func decodeKeyAs[K comparable](data []byte) (K, error) {
+	var key K
+	v, err := decodeKey(data)
+	if err != nil {
+		return key, err
+	}
+	key, ok := v.(K)
+	if !ok {
+		return key, fmt.Errorf("Key of type %T does not match the index key type %T", v, key)
+	}
+	return key, nil
+}
+
+// WriteTo serializes the index to w, including keys that have been added
+// but not yet indexed. It implements io.WriterTo.
+// Only string and integer keys, and at most 65536 hash functions are
+// supported.
+func (e *LshEnsemble[K]) WriteTo(w io.Writer) (int64, error) {
+	e.layoutMu.RLock()
+	parts, lshes, overflow := e.Partitions, e.lshes, e.overflow
+	e.layoutMu.RUnlock()
+	kind, err := lshKind(lshes)
+	if err != nil {
+		return 0, err
+	}
+	if e.numHash > maxNumHash {
+		return 0, fmt.Errorf("Cannot serialize more than %d hash functions", maxNumHash)
+	}
+	cw := &countingWriter{w: w}
+	bw := bufio.NewWriter(cw)
+	crc := crc32.NewIEEE()
+	b := &binWriter{w: io.MultiWriter(bw, crc)}
+	b.bytes([]byte(indexMagic))
+	b.u32(indexVersion)
+	b.u32(uint32(e.numHash))
+	b.u32(uint32(e.maxK))
+	b.u8(kind)
+	var sigInfo SignatureInfo
+	e.sigInfoMu.Lock()
+	if e.sigInfo != nil {
+		sigInfo = *e.sigInfo
+	}
+	e.sigInfoMu.Unlock()
+	b.u8(byte(sigInfo.Family))
+	b.u64(uint64(sigInfo.Seed))
+	if e.normalizer != nil {
+		b.u8(1)
+		b.u8(e.normalizer.flags())
+		b.u32(uint32(len(e.normalizer.Custom)))
+		b.bytes([]byte(e.normalizer.Custom))
+	} else {
+		b.u8(0)
+		b.u8(0)
+		b.u32(0)
+	}
+	b.u8(byte(e.routing))
+	if overflow {
+		b.u8(1)
+	} else {
+		b.u8(0)
+	}
+	b.u64(math.Float64bits(e.sizeError))
+	b.u32(uint32(len(parts)))
+	for _, p := range parts {
+		b.u64(uint64(p.Lower))
+		b.u64(uint64(p.Upper))
+	}
+	for i := range lshes {
+		switch lsh := lshes[i].(type) {
+		case *LshForest[K]:
+			err = lsh.write(b)
+		case *LshForestArray[K]:
+			for _, f := range lsh.array {
+				if err = f.write(b); err != nil {
+					break
+				}
+			}
+		}
+		if err != nil {
+			return cw.n, err
+		}
+	}
+	// The checksum itself is not part of the checksum.
+	b.w = bw
+	b.u32(crc.Sum32())
+	if b.err != nil {
+		return cw.n, b.err
+	}
+	err = bw.Flush()
+	return cw.n, err
+}
+
+func lshKind[K comparable](lshes []Lsh[K]) (byte, error) {
+	if len(lshes) == 0 {
+		return lshKindForest, nil
+	}
+	var kind byte
+	for _, lsh := range lshes {
+		var k byte
+		switch lsh.(type) {
+		case *LshForest[K]:
+			k = lshKindForest
+		case *LshForestArray[K]:
+			k = lshKindForestArray
+		default:
+			return 0, fmt.Errorf("Cannot serialize Lsh of type %T", lsh)
+		}
+		if kind != 0 && kind != k {
+			return 0, errors.New("Cannot serialize mixed Lsh types")
+		}
+		kind = k
+	}
+	return kind, nil
+}
+
+// ReadLshEnsemble reads an index previously written by WriteTo.
+// Keys added but not indexed at the time of writing are restored,
+// and remain unsearchable until Index() is called.
+func ReadLshEnsemble[K comparable](r io.Reader) (*LshEnsemble[K], error) {
+	crc := crc32.NewIEEE()
+	br := bufio.NewReader(r)
+	b := &binReader{r: io.TeeReader(br, crc)}
+	magic := make([]byte, len(indexMagic))
+	b.bytes(magic)
+	if b.err != nil {
+		return nil, b.err
+	}
+	if string(magic) != indexMagic {
+		return nil, errBadMagic
+	}
+	version := b.u32()
+	if b.err == nil && (version < 1 || version > indexVersion) {
+		return nil, fmt.Errorf("Unsupported index version %d", version)
+	}
+	numHash := int(b.u32())
+	maxK := int(b.u32())
+	kind := b.u8()
+	var sigInfo SignatureInfo
+	if version >= 3 {
+		sigInfo.Family = HashFamily(b.u8())
+		sigInfo.Seed = int64(b.u64())
+	}
+	var normalizer *Normalizer
+	if version >= 4 {
+		hasNormalizer := b.u8()
+		flags := b.u8()
+		custom := b.next(int(b.u32()))
+		if b.err != nil {
+			return nil, b.err
+		}
+		if hasNormalizer != 0 {
+			var err error
+			if normalizer, err = newNormalizer(flags, string(custom)); err != nil {
+				return nil, err
+			}
+		}
+	}
+	routing, overflow := RejectOutOfRange, false
+	if version >= 5 {
+		routing = RoutingPolicy(b.u8())
+		overflow = b.u8() != 0
+	}
+	var sizeError float64
+	if version >= 6 {
+		sizeError = math.Float64frombits(b.u64())
+	}
+	numPart := int(b.u32())
+	if b.err != nil {
+		return nil, b.err
+	}
+	if routing > OverflowPartition {
+		return nil, fmt.Errorf("Unknown routing policy %d", routing)
+	}
+	if !(sizeError >= 0 && sizeError <= 1) {
+		return nil, errors.New("Invalid size error")
+	}
+	if kind != lshKindForest && kind != lshKindForestArray {
+		return nil, fmt.Errorf("Unknown Lsh kind %d", kind)
+	}
+	if maxK <= 0 || numHash < maxK || numHash > maxNumHash {
+		return nil, errors.New("Invalid numHash or maxK")
+	}
+	// The partitions and forests are allocated as they are read, so
+	// corrupted counts fail at the end of the input.
+	var parts []Partition
+	for i := 0; i < numPart && b.err == nil; i++ {
+		lower := int(int64(b.u64()))
+		upper := int(int64(b.u64()))
+		parts = append(parts, Partition{lower, upper})
+	}
+	opts := []Option{Routing(routing), EstimatedSizes(sizeError)}
+	if sigInfo.Family != 0 {
+		sigInfo.NumHash = numHash
+		opts = append(opts, SignatureConfig(sigInfo))
+	}
+	if normalizer != nil {
+		opts = append(opts, Normalization(*normalizer))
+	}
+	var e *LshEnsemble[K]
+	if kind == lshKindForest {
+		e = NewLshEnsemble[K](nil, numHash, maxK, 0, opts...)
+	} else {
+		e = NewLshEnsemblePlus[K](nil, numHash, maxK, 0, opts...)
+	}
+	var lshes []Lsh[K]
+	for range parts {
+		if kind == lshKindForest {
+			f, err := readLshForest[K](b, version, maxK, numHash/maxK)
+			if err != nil {
+				return nil, err
+			}
+			lshes = append(lshes, f)
+			continue
+		}
+		a := &LshForestArray[K]{
+			maxK:    maxK,
+			numHash: numHash,
+			array:   make([]*LshForest[K], maxK),
+		}
+		for j := range a.array {
+			f, err := readLshForest[K](b, version, j+1, numHash/(j+1))
+			if err != nil {
+				return nil, err
+			}
+			a.array[j] = f
+		}
+		lshes = append(lshes, a)
+	}
+	e.Partitions, e.lshes, e.overflow = parts, lshes, overflow
+	if b.err != nil {
+		return nil, b.err
+	}
+	sum := crc.Sum32()
+	b.r = br
+	if stored := b.u32(); b.err != nil {
+		return nil, b.err
+	} else if stored != sum {
+		return nil, errBadChecksum
+	}
+	return e, nil
+}
+
+// write serializes the forest, see the format description above.
+func (f *LshForest[K]) write(b *binWriter) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	// The pending entries taken by a rebuild are in neither the tables nor
+	// the pending entries until it finishes.
+	f.waitRebuild()
+	// Write the forest as if removed keys were compacted, and the
+	// pending entries were added.
+	tables, numIndexedKeys := f.hashTables, f.numIndexedKeys
+	if f.tombstones.len() > 0 {
+		tables, numIndexedKeys = compactTables(tables, numIndexedKeys, f.keys, f.tombstones)
+	}
+	numEntries := len(f.pending)
+	if len(tables) > 0 {
+		numEntries += tables[0].Len()
+	}
+	b.u32(uint32(f.k))
+	b.u32(uint32(f.l))
+	b.u32(uint32(f.hashValueBits))
+	b.u64(uint64(numEntries))
+	b.u64(uint64(numIndexedKeys))
+	// Build a dictionary of the keys that have entries, so each key is
+	// written only once, and renumber the ids of the entries.
+	const unused = ^uint32(0)
+	remap := make([]uint32, len(f.keys))
+	for i := range remap {
+		remap[i] = unused
+	}
+	var blob []byte
+	offsets := []uint64{0}
+	addKey := func(key K) (uint32, error) {
+		var err error
+		if blob, err = encodeKey(blob, key); err != nil {
+			return 0, err
+		}
+		offsets = append(offsets, uint64(len(blob)))
+		return uint32(len(offsets) - 2), nil
+	}
+	if len(tables) > 0 {
+		for _, id := range tables[0].ids {
+			if remap[id] != unused {
+				continue
+			}
+			var err error
+			if remap[id], err = addKey(f.keys[id]); err != nil {
+				return err
+			}
+		}
+	}
+	// The entries of pending keys are compacted, so their keys are new.
+	pendingIDs := make([]uint32, len(f.pending))
+	seen := make(map[K]uint32)
+	for i, p := range f.pending {
+		id, exists := seen[p.key]
+		if !exists {
+			var err error
+			if id, err = addKey(p.key); err != nil {
+				return err
+			}
+			seen[p.key] = id
+		}
+		pendingIDs[i] = id
+	}
+	b.u64(uint64(len(offsets) - 1))
+	for _, o := range offsets {
+		b.u64(o)
+	}
+	b.bytes(blob)
+	idBuf := make([]byte, 4*numEntries)
+	for i, ht := range tables {
+		b.bytes(ht.hashKeys)
+		for _, p := range f.pending {
+			b.bytes(p.hashKeys[i*ht.width : (i+1)*ht.width])
+		}
+		for j, id := range ht.ids {
+			binary.LittleEndian.PutUint32(idBuf[4*j:], remap[id])
+		}
+		for j, id := range pendingIDs {
+			binary.LittleEndian.PutUint32(idBuf[4*(ht.Len()+j):], id)
+		}
+		b.bytes(idBuf)
+	}
+	return b.err
+}
+
+// hashValueBits converts the stored hash value size of the given
+// format version to bits, and validates it.
+func hashValueBits(version uint32, stored int) (int, error) {
+	bits := stored
+	if version == 1 {
+		bits = stored * 8
+	}
+	switch {
+	case bits >= 1 && bits <= 8, bits == 16, bits == 32, bits == 64:
+		return bits, nil
+	}
+	return 0, fmt.Errorf("Invalid hash value size %d", stored)
+}
+
+// checkForestHeader validates the header of a forest against the layout
+// of the forests created by NewLshEnsemble and NewLshEnsemblePlus, and
+// checks the counts can be used to size the sections without overflow.
+// Every entry takes the hash key width and a key id of 4 bytes in every
+// table, and every key an offset of 8 bytes.
+func checkForestHeader(k, l, wantK, wantL, width, numEntries, numIndexedKeys, numKeys int) error {
+	switch {
+	case k != wantK || l != wantL:
+		return fmt.Errorf("Invalid forest with k = %d and l = %d, expecting k = %d and l = %d",
+			k, l, wantK, wantL)
+	case numEntries < 0 || numEntries > math.MaxInt/(width+12):
+		return errors.New("Invalid number of entries")
+	case numIndexedKeys < 0 || numIndexedKeys > numEntries:
+		return errors.New("Invalid number of indexed keys")
+	case numKeys < 0 || numKeys > numEntries || numKeys > maxForestKeys:
+		return errors.New("Invalid number of keys")
+	}
+	return nil
+}
+
+func readLshForest[K comparable](b *binReader, version uint32, wantK, wantL int) (*LshForest[K], error) {
+	k := int(b.u32())
+	l := int(b.u32())
+	stored := int(b.u32())
+	numEntries := int(b.u64())
+	numIndexedKeys := int(b.u64())
+	numKeys := int(b.u64())
+	if b.err != nil {
+		return nil, b.err
+	}
+	bits, err := hashValueBits(version, stored)
+	if err != nil {
+		return nil, err
+	}
+	width := hashKeyWidth(wantK, bits)
+	if err := checkForestHeader(k, l, wantK, wantL, width, numEntries, numIndexedKeys, numKeys); err != nil {
+		return nil, err
+	}
+	offsetBuf := b.next(8 * (numKeys + 1))
+	if b.err != nil {
+		return nil, b.err
+	}
+	offsets := make([]uint64, numKeys+1)
+	for i := range offsets {
+		offsets[i] = binary.LittleEndian.Uint64(offsetBuf[8*i:])
+	}
+	if offsets[numKeys] > math.MaxInt {
+		return nil, errors.New("Invalid key offsets")
+	}
+	blob := b.next(int(offsets[numKeys]))
+	if b.err != nil {
+		return nil, b.err
+	}
+	keys := make([]K, numKeys)
+	for i := range keys {
+		if offsets[i] > offsets[i+1] || offsets[i+1] > uint64(len(blob)) {
+			return nil, errors.New("Invalid key offsets")
+		}
+		key, err := decodeKeyAs[K](blob[offsets[i]:offsets[i+1]])
+		if err != nil {
+			return nil, err
+		}
+		keys[i] = key
+	}
+	f := newLshForest[K](k, l, bits, 0)
+	f.keys = keys
+	for i, key := range keys {
+		f.keyIDs[key] = uint32(i)
+	}
+	for i := range f.hashTables {
+		hashKeys := b.next(width * numEntries)
+		idBuf := b.next(4 * numEntries)
+		if b.err != nil {
+			return nil, b.err
+		}
+		ids := make([]uint32, numEntries)
+		for j := range ids {
+			ids[j] = binary.LittleEndian.Uint32(idBuf[4*j:])
+			if int(ids[j]) >= numKeys {
+				return nil, errors.New("Invalid key id")
+			}
+		}
+		f.hashTables[i] = hashTable{width, hashKeys, ids}
+	}
+	f.numIndexedKeys = numIndexedKeys
+	f.publish(nil)
+	return f, nil
+}
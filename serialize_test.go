@@ -0,0 +1,86 @@
+package lshensemble
+
+import (
+	"bytes"
+	"encoding/binary"
+	"slices"
+	"testing"
+)
+
+func Test_LshEnsembleWriteRead(t *testing.T) {
+	recs := testDomainRecords(100, 64)
+	for _, index := range testIndexes(t, recs) {
+		var buf bytes.Buffer
+		n, err := index.WriteTo(&buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n != int64(buf.Len()) {
+			t.Fatalf("WriteTo reported %d bytes, wrote %d", n, buf.Len())
+		}
+		data := buf.Bytes()
+		loaded, err := ReadLshEnsemble[string](bytes.NewReader(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(loaded.Partitions) != len(index.Partitions) {
+			t.Fatal(loaded.Partitions)
+		}
+		for _, rec := range recs {
+			expected, _, err := index.QueryTimed(rec.Signature, rec.Size, 0.8)
+			if err != nil {
+				t.Fatal(err)
+			}
+			result, _, err := loaded.QueryTimed(rec.Signature, rec.Size, 0.8)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(expected) != len(result) {
+				t.Fatalf("Expected %d candidates, got %d", len(expected), len(result))
+			}
+		}
+		// Corrupting a byte must be detected.
+		data[len(data)/2] ^= 0xff
+		if _, err := ReadLshEnsemble[string](bytes.NewReader(data)); err == nil {
+			t.Fatal("Corrupted index was read without error")
+		}
+	}
+}
+
+func Test_LshEnsembleReadCorruptedHeader(t *testing.T) {
+	index := testIndexes(t, testDomainRecords(100, 64))[0]
+	var buf bytes.Buffer
+	if _, err := index.WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	// The first forest follows the header and the 4 partitions.
+	forest := len(indexMagic) + 4 + 4 + 4 + 1 + 1 + 8 + 1 + 1 + 4 + 1 + 1 + 8 + 4 + 4*16
+	if k := binary.LittleEndian.Uint32(buf.Bytes()[forest:]); k != 4 {
+		t.Fatalf("Forest at offset %d has k = %d, expecting 4", forest, k)
+	}
+	for _, c := range []struct {
+		name string
+		off  int
+		v    uint64
+		size int
+	}{
+		{"k", forest, 1 << 30, 4},
+		{"l", forest + 4, 0, 4},
+		{"numEntries", forest + 12, 1 << 62, 8},
+		{"numKeys", forest + 28, 1 << 62, 8},
+		{"numHash", len(indexMagic) + 4, 1 << 31, 4},
+	} {
+		data := slices.Clone(buf.Bytes())
+		if c.size == 4 {
+			binary.LittleEndian.PutUint32(data[c.off:], uint32(c.v))
+		} else {
+			binary.LittleEndian.PutUint64(data[c.off:], c.v)
+		}
+		if _, err := ReadLshEnsemble[string](bytes.NewReader(data)); err == nil {
+			t.Errorf("Index with corrupted %s was read without error", c.name)
+		}
+		if _, err := readMappedLshEnsemble[string](data, false); err == nil {
+			t.Errorf("Index with corrupted %s was mapped without error", c.name)
+		}
+	}
+}
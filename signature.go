@@ -0,0 +1,195 @@
+package lshensemble
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// The self-describing signature format is (all integers are big-endian,
+// as in SigToBytes):
+//
+//	magic "LSHS" | version u8 | hash family u8 | seed i64 | numHash u32 |
+//	numHash * hash value u64
+const (
+	signatureMagic   = "LSHS"
+	signatureVersion = 1
+	// signatureHeaderSize is the number of bytes before the hash values.
+	signatureHeaderSize = len(signatureMagic) + 1 + 1 + 8 + 4
+)
+
+// ErrSignatureMismatch is returned when a signature does not match the
+// configuration of the index, for example when it is created using a
+// different seed or number of hash functions.
+var ErrSignatureMismatch = errors.New("Signature does not match the index")
+
+// HashFamily identifies the sketch used to create a signature.
+type HashFamily uint8
+
+// Hash families of the sketches in this package.
+const (
+	// HashFamilyMinhash is used by Minhash.
+	HashFamilyMinhash HashFamily = iota + 1
+	// HashFamilyOnePermutation is used by OnePermutationMinhash.
+	HashFamilyOnePermutation
+	// HashFamilyWeighted is used by WeightedMinhash.
+	HashFamilyWeighted
+	// HashFamilyXXHash is used by Minhash with two seeded xxhash functions.
+	HashFamilyXXHash
+	// HashFamilyMurmur3 is used by Minhash with two seeded murmur3 functions.
+	HashFamilyMurmur3
+	// HashFamilyDatasketch is used by Minhash with the permutations of
+	// the datasketch Python library.
+	HashFamilyDatasketch
+)
+
+func (f HashFamily) String() string {
+	switch f {
+	case HashFamilyMinhash:
+		return "minhash"
+	case HashFamilyOnePermutation:
+		return "one-permutation"
+	case HashFamilyWeighted:
+		return "weighted"
+	case HashFamilyXXHash:
+		return "xxhash"
+	case HashFamilyMurmur3:
+		return "murmur3"
+	case HashFamilyDatasketch:
+		return "datasketch"
+	}
+	return fmt.Sprintf("HashFamily(%d)", uint8(f))
+}
+
+// SignatureInfo describes how a signature is created. Only signatures
+// with the same SignatureInfo can be compared.
+type SignatureInfo struct {
+	Family  HashFamily
+	Seed    int64
+	NumHash int
+}
+
+// SignatureInfo returns the description of the signatures of m.
+func (m *Minhash) SignatureInfo() SignatureInfo {
+	return SignatureInfo{m.family, m.seed, len(m.Signature())}
+}
+
+// SignatureInfo returns the description of the signatures of m.
+func (m *OnePermutationMinhash) SignatureInfo() SignatureInfo {
+	return SignatureInfo{HashFamilyOnePermutation, m.initSeed, len(m.bins)}
+}
+
+// SignatureInfo returns the description of the signatures of m.
+func (m *WeightedMinhash) SignatureInfo() SignatureInfo {
+	return SignatureInfo{HashFamilyWeighted, m.initSeed, len(m.sig)}
+}
+
+// EncodeSignature serializes the signature together with its description,
+// so it can be validated when it is decoded.
+func EncodeSignature(info SignatureInfo, sig []uint64) []byte {
+	data := make([]byte, signatureHeaderSize+8*len(sig))
+	copy(data, signatureMagic)
+	b := data[len(signatureMagic):]
+	b[0] = signatureVersion
+	b[1] = byte(info.Family)
+	binary.BigEndian.PutUint64(b[2:], uint64(info.Seed))
+	binary.BigEndian.PutUint32(b[10:], uint32(len(sig)))
+	for i, v := range sig {
+		binary.BigEndian.PutUint64(data[signatureHeaderSize+8*i:], v)
+	}
+	return data
+}
+
+// DecodeSignature reverses EncodeSignature.
+func DecodeSignature(data []byte) (SignatureInfo, []uint64, error) {
+	var info SignatureInfo
+	if len(data) < signatureHeaderSize || string(data[:len(signatureMagic)]) != signatureMagic {
+		return info, nil, errors.New("Not an encoded signature")
+	}
+	b := data[len(signatureMagic):]
+	if b[0] != signatureVersion {
+		return info, nil, fmt.Errorf("Unsupported signature version %d", b[0])
+	}
+	info.Family = HashFamily(b[1])
+	info.Seed = int64(binary.BigEndian.Uint64(b[2:]))
+	info.NumHash = int(binary.BigEndian.Uint32(b[10:]))
+	if len(data)-signatureHeaderSize != 8*info.NumHash {
+		return info, nil, errors.New("Encoded signature has wrong length")
+	}
+	sig := make([]uint64, info.NumHash)
+	for i := range sig {
+		sig[i] = binary.BigEndian.Uint64(data[signatureHeaderSize+8*i:])
+	}
+	return info, sig, nil
+}
+
+// SignatureConfig makes the index only accept encoded signatures
+// described by info, a zero NumHash stands for the number of hash
+// functions of the index. Without the option, the index accepts encoded
+// signatures described the same as the first one added.
+func SignatureConfig(info SignatureInfo) Option {
+	return func(o *options) {
+		o.sigInfo = &info
+	}
+}
+
+// checkSignature validates the number of hash values in the signature.
+func (e *LshEnsemble[K]) checkSignature(sig []uint64) error {
+	if len(sig) != e.numHash {
+		return fmt.Errorf("%w: signature has %d hash values, expecting %d",
+			ErrSignatureMismatch, len(sig), e.numHash)
+	}
+	return nil
+}
+
+// checkSignatureInfo validates the description of an encoded signature
+// against the index configuration. If the index has no configuration yet
+// and adding is true, the description becomes the configuration.
+func (e *LshEnsemble[K]) checkSignatureInfo(info SignatureInfo, adding bool) error {
+	if info.NumHash != e.numHash {
+		return fmt.Errorf("%w: signature has %d hash values, expecting %d",
+			ErrSignatureMismatch, info.NumHash, e.numHash)
+	}
+	e.sigInfoMu.Lock()
+	defer e.sigInfoMu.Unlock()
+	if e.sigInfo == nil {
+		if adding {
+			e.sigInfo = &info
+		}
+		return nil
+	}
+	if info != *e.sigInfo {
+		return fmt.Errorf("%w: signature is %s with seed %d, expecting %s with seed %d",
+			ErrSignatureMismatch, info.Family, info.Seed, e.sigInfo.Family, e.sigInfo.Seed)
+	}
+	return nil
+}
+
+// AddEncoded is similar to Add, but takes a signature serialized using
+// EncodeSignature, and returns an error if it does not match the index.
+func (e *LshEnsemble[K]) AddEncoded(key K, data []byte, partInd int) error {
+	if e.readOnly {
+		return errReadOnly
+	}
+	info, sig, err := DecodeSignature(data)
+	if err != nil {
+		return err
+	}
+	if err := e.checkSignatureInfo(info, true); err != nil {
+		return err
+	}
+	return e.Add(key, sig, partInd)
+}
+
+// QueryEncoded is similar to Query, but takes a signature serialized using
+// EncodeSignature, and returns an error if it does not match the index.
+func (e *LshEnsemble[K]) QueryEncoded(data []byte, size int, threshold float64, done <-chan struct{}) (<-chan K, error) {
+	info, sig, err := DecodeSignature(data)
+	if err != nil {
+		return nil, err
+	}
+	if err := e.checkSignatureInfo(info, false); err != nil {
+		return nil, err
+	}
+	return e.Query(sig, size, threshold, done)
+}
@@ -0,0 +1,110 @@
+package lshensemble
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func Test_EncodeSignature(t *testing.T) {
+	m := NewMinhash(42, 16)
+	m.Push([]byte("a"))
+	data := EncodeSignature(m.SignatureInfo(), m.Signature())
+	info, sig, err := DecodeSignature(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info != (SignatureInfo{HashFamilyMinhash, 42, 16}) {
+		t.Fatalf("Decoded signature info %+v", info)
+	}
+	for i := range sig {
+		if sig[i] != m.Signature()[i] {
+			t.Fatal("Decoded signature is different")
+		}
+	}
+	if _, _, err := DecodeSignature(data[:len(data)-1]); err == nil {
+		t.Error("Decoding a truncated signature should fail")
+	}
+	if _, _, err := DecodeSignature(SigToBytes(sig)); err == nil {
+		t.Error("Decoding a bare signature should fail")
+	}
+}
+
+func Test_LshEnsembleSignatureMismatch(t *testing.T) {
+	parts := []Partition{{Lower: 1, Upper: 10}}
+	index := NewLshEnsemble[string](parts, 16, 4, 10)
+	encode := func(seed int64, numHash int) []byte {
+		m := NewMinhash(seed, numHash)
+		m.Push([]byte("a"))
+		return EncodeSignature(m.SignatureInfo(), m.Signature())
+	}
+	if err := index.AddEncoded("a", encode(1, 16), 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := index.AddEncoded("b", encode(2, 16), 0); !errors.Is(err, ErrSignatureMismatch) {
+		t.Errorf("Adding a signature with a different seed returned %v", err)
+	}
+	if err := index.AddEncoded("c", encode(1, 32), 0); !errors.Is(err, ErrSignatureMismatch) {
+		t.Errorf("Adding a signature with a different numHash returned %v", err)
+	}
+	if err := index.Add("d", randomSignature(8, 1), 0); !errors.Is(err, ErrSignatureMismatch) {
+		t.Errorf("Adding a short signature returned %v", err)
+	}
+	index.Index()
+	if _, err := index.Query(randomSignature(8, 1), 1, 0.5, nil); !errors.Is(err, ErrSignatureMismatch) {
+		t.Errorf("Querying a short signature returned %v", err)
+	}
+	if _, err := index.QueryEncoded(encode(2, 16), 1, 0.5, nil); !errors.Is(err, ErrSignatureMismatch) {
+		t.Errorf("Querying a signature with a different seed returned %v", err)
+	}
+	batch := []Query[string]{{"a", randomSignature(16, 1), 1, 0.5}, {"b", randomSignature(32, 1), 1, 0.5}}
+	if _, err := index.QueryBatch(batch, 2, false); !errors.Is(err, ErrSignatureMismatch) {
+		t.Errorf("Querying a batch with a long signature returned %v", err)
+	}
+	if _, _, err := index.QueryExplain(randomSignature(32, 1), 1, 0.5); !errors.Is(err, ErrSignatureMismatch) {
+		t.Errorf("Explaining a query with a long signature returned %v", err)
+	}
+	if _, _, err := index.QueryTimed(randomSignature(32, 1), 1, 0.5); !errors.Is(err, ErrSignatureMismatch) {
+		t.Errorf("Timing a query with a long signature returned %v", err)
+	}
+	done := make(chan struct{})
+	defer close(done)
+	results, err := index.QueryEncoded(encode(1, 16), 1, 1.0, done)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var found bool
+	for key := range results {
+		if key == "a" {
+			found = true
+		}
+	}
+	if !found {
+		t.Error("Query should find the identical signature")
+	}
+
+	// The signature configuration is saved with the index.
+	var buf bytes.Buffer
+	if _, err := index.WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := ReadLshEnsemble[string](&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := loaded.QueryEncoded(encode(2, 16), 1, 0.5, nil); !errors.Is(err, ErrSignatureMismatch) {
+		t.Errorf("Querying the loaded index with a different seed returned %v", err)
+	}
+}
+
+func Test_SignatureConfigNumHash(t *testing.T) {
+	parts := []Partition{{Lower: 1, Upper: 10}}
+	// A zero NumHash stands for the number of hash functions of the index.
+	index := NewLshEnsemble[string](parts, 16, 4, 10,
+		SignatureConfig(SignatureInfo{Family: HashFamilyMinhash, Seed: 1}))
+	m := NewMinhash(1, 16)
+	m.Push([]byte("a"))
+	if err := index.AddEncoded("a", EncodeSignature(m.SignatureInfo(), m.Signature()), 0); err != nil {
+		t.Fatal(err)
+	}
+}
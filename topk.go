@@ -0,0 +1,146 @@
+package lshensemble
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// topKThresholdStep is the amount by which QueryTopK lowers the containment
+// threshold when fewer than k candidates pass.
+const topKThresholdStep = 0.1
+
+var errNoDomains = errors.New("Index does not keep domains, use the KeepDomains option")
+
+// ScoredKey is a domain key with its estimated containment score.
+type ScoredKey[K comparable] struct {
+	Key   K
+	Score float64
+}
+
+type storedDomain struct {
+	sig []uint64
+	// packed is the packed signature when b-bit MinHash is used.
+	packed []byte
+	// size is the domain size if exact is true. The size of a domain
+	// added using Add is unknown, and size is the upper bound of its
+	// partition, which only places the domain when the index is
+	// rebalanced.
+	size  int
+	exact bool
+}
+
+// domainStore keeps the signatures and sizes of indexed domains.
+type domainStore[K comparable] struct {
+	mu      sync.RWMutex
+	domains map[K]storedDomain
+	// hashValueBits is the b of b-bit MinHash, 0 if signatures are
+	// stored in full.
+	hashValueBits int
+}
+
+func (s *domainStore[K]) put(key K, sig []uint64, size int, exact bool) {
+	d := storedDomain{sig: sig, size: size, exact: exact}
+	if s.hashValueBits > 0 {
+		d = storedDomain{packed: PackSignature(sig, s.hashValueBits), size: size, exact: exact}
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.domains[key] = d
+}
+
+func (s *domainStore[K]) get(key K) (storedDomain, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	d, exists := s.domains[key]
+	return d, exists
+}
+
+func (s *domainStore[K]) remove(key K) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.domains, key)
+}
+
+// containment estimates the containment of the query domain in d.
+func (s *domainStore[K]) containment(sig []uint64, size int, d storedDomain) float64 {
+	if d.packed != nil {
+		x := UnpackSignature(d.packed, s.hashValueBits, len(sig))
+		return ContainmentBBit(sig, x, size, d.size, s.hashValueBits)
+	}
+	return Containment(sig, d.sig, size, d.size)
+}
+
+// KeepDomains makes the index keep the signature and size of every added
+// domain, so the candidates can be ranked by QueryTopK.
+// This increases the memory usage of the index by the size of the signatures.
+// The kept domains are not saved by WriteTo.
+func KeepDomains() Option {
+	return func(o *options) {
+		o.keepDomains = true
+	}
+}
+
+// QueryTopK returns the keys of at most k domains with the highest
+// estimated containment of the query domain, in descending order of the
+// scores. The scores are estimated using Containment, or ContainmentBBit
+// for b-bit MinHash.
+// The containment threshold starts at 1.0 and is lowered until at least k
+// candidates pass, so fewer than k keys are returned only when there are
+// not enough candidates at the lowest threshold.
+// k must be positive. The index must be created with the KeepDomains option.
+// Domains added using Add have no known sizes and are not ranked, use
+// Prepare or Update instead.
+func (e *LshEnsemble[K]) QueryTopK(sig []uint64, size, k int) ([]ScoredKey[K], error) {
+	if k <= 0 {
+		return nil, fmt.Errorf("Invalid number of keys %d", k)
+	}
+	if e.domains == nil {
+		return nil, errNoDomains
+	}
+	if err := e.checkSignature(sig); err != nil {
+		return nil, err
+	}
+	scores := make(map[K]float64)
+	var result []ScoredKey[K]
+	for threshold := 1.0; threshold > topKThresholdStep/2; threshold -= topKThresholdStep {
+		candidates, _, err := e.QueryTimed(sig, size, threshold)
+		if err != nil {
+			return nil, err
+		}
+		for _, key := range candidates {
+			if _, scored := scores[key]; scored {
+				continue
+			}
+			d, exists := e.domains.get(key)
+			if !exists || !d.exact {
+				continue
+			}
+			scores[key] = e.domains.containment(sig, size, d)
+		}
+		result = result[:0]
+		for key, score := range scores {
+			if score >= threshold {
+				result = append(result, ScoredKey[K]{key, score})
+			}
+		}
+		if len(result) >= k {
+			break
+		}
+	}
+	// Candidates below the lowest threshold are still better than nothing.
+	if len(result) < k {
+		result = result[:0]
+		for key, score := range scores {
+			result = append(result, ScoredKey[K]{key, score})
+		}
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Score > result[j].Score
+	})
+	if len(result) > k {
+		result = result[:k]
+	}
+	return result, nil
+}
@@ -0,0 +1,54 @@
+package lshensemble
+
+import "testing"
+
+func Test_LshEnsembleQueryTopK(t *testing.T) {
+	recs := testDomainRecords(100, 64)
+	index, err := BootstrapLshEnsembleEquiDepth(4, 64, 4, len(recs), Recs2Chan(recs))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := index.QueryTopK(recs[0].Signature, recs[0].Size, 3); err != errNoDomains {
+		t.Fatal("Expected error for index without domains")
+	}
+	index, err = BootstrapLshEnsembleEquiDepth(4, 64, 4, len(recs), Recs2Chan(recs),
+		KeepDomains())
+	if err != nil {
+		t.Fatal(err)
+	}
+	query := recs[50]
+	for _, k := range []int{0, -1} {
+		if _, err := index.QueryTopK(query.Signature, query.Size, k); err == nil {
+			t.Fatalf("Expected error for k = %d", k)
+		}
+	}
+	result, err := index.QueryTopK(query.Signature, query.Size, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result) == 0 || len(result) > 3 {
+		t.Fatalf("Expected 1 to 3 results, got %d", len(result))
+	}
+	if result[0].Key != query.Key || result[0].Score != 1.0 {
+		t.Fatalf("Expected %v with score 1.0 first, got %v", query.Key, result[0])
+	}
+	for i := 1; i < len(result); i++ {
+		if result[i].Score > result[i-1].Score {
+			t.Fatal("Results are not sorted by score")
+		}
+	}
+	// A domain added without its size is not ranked.
+	if err := index.Add("unsized", query.Signature, len(index.Partitions)-1); err != nil {
+		t.Fatal(err)
+	}
+	index.Index()
+	result, err = index.QueryTopK(query.Signature, query.Size, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, r := range result {
+		if r.Key == "unsized" {
+			t.Fatal("Domain without size was ranked")
+		}
+	}
+}
@@ -5,26 +5,90 @@ import (
 	"sort"
 )
 
-type hashKeyFunc func([]uint64) string
+// hashKeyFunc appends the hash key of the hash values in sig to dst,
+// and returns the extended slice.
+type hashKeyFunc func(dst []byte, sig []uint64) []byte
 
 func hashKeyFuncGen(hashValueSize int) hashKeyFunc {
-	return func(sig []uint64) string {
-		s := make([]byte, hashValueSize*len(sig))
-		buf := make([]byte, 8)
-		for i, v := range sig {
-			binary.LittleEndian.PutUint64(buf, v)
-			copy(s[i*hashValueSize:(i+1)*hashValueSize], buf[:hashValueSize])
+	return func(dst []byte, sig []uint64) []byte {
+		var buf [8]byte
+		for _, v := range sig {
+			binary.LittleEndian.PutUint64(buf[:], v)
+			dst = append(dst, buf[:hashValueSize]...)
 		}
-		return string(s)
+		return dst
 	}
 }
 
+// packedHashKeyFuncGen returns a hashKeyFunc that packs the lowest
+// hashValueBits bits of every hash value, most significant bit first,
+// so the order of hash keys is the lexicographic order of the hash values.
+func packedHashKeyFuncGen(hashValueBits int) hashKeyFunc {
+	mask := uint64(1)<<uint(hashValueBits) - 1
+	return func(dst []byte, sig []uint64) []byte {
+		start := len(dst)
+		dst = append(dst, make([]byte, hashKeyWidth(len(sig), hashValueBits))...)
+		s := dst[start:]
+		var pos int
+		for _, v := range sig {
+			v &= mask
+			for bit := hashValueBits - 1; bit >= 0; bit-- {
+				if v>>uint(bit)&1 == 1 {
+					s[pos/8] |= 0x80 >> uint(pos%8)
+				}
+				pos++
+			}
+		}
+		return dst
+	}
+}
+
+// hashKeyWidth returns the number of bytes in the hash key of k hash values.
+func hashKeyWidth(k, hashValueBits int) int {
+	return (k*hashValueBits + 7) / 8
+}
+
+// comparePrefix compares the first nbits bits of hash keys a and b,
+// the result is 0 if a == b, -1 if a < b, and +1 if a > b.
+func comparePrefix[T string | []byte](a, b T, nbits int) int {
+	n := nbits / 8
+	for i := 0; i < n; i++ {
+		if a[i] != b[i] {
+			if a[i] < b[i] {
+				return -1
+			}
+			return 1
+		}
+	}
+	if rem := nbits % 8; rem != 0 {
+		mask := byte(0xff) << uint(8-rem)
+		x, y := a[n]&mask, b[n]&mask
+		if x < y {
+			return -1
+		}
+		if x > y {
+			return 1
+		}
+	}
+	return 0
+}
+
+// prefixKey appends the first nbits bits of hash key hk to dst, with the
+// remaining bits of the last byte cleared, and returns the extended slice.
+func prefixKey(dst, hk []byte, nbits int) []byte {
+	dst = append(dst, hk[:(nbits+7)/8]...)
+	if rem := nbits % 8; rem != 0 {
+		dst[len(dst)-1] &= byte(0xff) << uint(8-rem)
+	}
+	return dst
+}
+
 type sizeCount struct {
 	size  int
 	count int
 }
 
-func computeSizeDistribution(domains <-chan *DomainRecord) (sizes, counts []int) {
+func computeSizeDistribution[K comparable](domains <-chan *DomainRecord[K]) (sizes, counts []int) {
 	m := make(map[int]int)
 	for d := range domains {
 		if _, exists := m[d.Size]; !exists {
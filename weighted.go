@@ -0,0 +1,123 @@
+package lshensemble
+
+import (
+	"encoding/binary"
+	"hash"
+	"hash/fnv"
+	"math"
+	"math/rand"
+)
+
+// WeightedMinhash represents a weighted MinHash object using Improved
+// Consistent Weighted Sampling (ICWS)
+// (https://static.googleusercontent.com/media/research.google.com/en//pubs/archive/36928.pdf).
+// A domain is a multiset, in which every distinct value has a weight,
+// for example its frequency. The probability of two signatures having the
+// same hash value is the weighted Jaccard similarity of the domains,
+// sum(min(w1, w2)) / sum(max(w1, w2)).
+type WeightedMinhash struct {
+	// initSeed is the seed given to NewWeightedMinhash.
+	initSeed int64
+	salt     []byte
+	seeds    []uint64
+	h        hash.Hash64
+	// mins are the minimum ICWS values, and sig the hash values of
+	// the sampled (value, weight) pairs.
+	mins        []float64
+	sig         []uint64
+	totalWeight float64
+}
+
+// NewWeightedMinhash initializes a weighted MinHash object with a seed and
+// the number of hash functions.
+func NewWeightedMinhash(seed int64, numHash int) *WeightedMinhash {
+	r := rand.New(rand.NewSource(seed))
+	salt := make([]byte, HashValueSize)
+	binary.BigEndian.PutUint64(salt, uint64(r.Int63()))
+	seeds := make([]uint64, numHash)
+	mins := make([]float64, numHash)
+	for i := range seeds {
+		seeds[i] = uint64(r.Int63())
+		mins[i] = math.Inf(1)
+	}
+	return &WeightedMinhash{
+		initSeed: seed,
+		salt:     salt,
+		seeds:    seeds,
+		h:        fnv.New64a(),
+		mins:     mins,
+		sig:      make([]uint64, numHash),
+	}
+}
+
+// Push a distinct value with its weight to the weighted MinHash object.
+// The value should be serialized to byte slice, and the weight must be
+// positive, otherwise the value is ignored. The weights of a value pushed
+// more than once are not added up, so every value should be pushed once
+// with its total weight.
+func (m *WeightedMinhash) Push(b []byte, weight float64) {
+	if !(weight > 0) {
+		return
+	}
+	m.totalWeight += weight
+	m.h.Reset()
+	m.h.Write(m.salt)
+	m.h.Write(b)
+	v := m.h.Sum64()
+	lnWeight := math.Log(weight)
+	for i, seed := range m.seeds {
+		// The random variables depend only on the value and the hash
+		// function, so they are consistent across domains.
+		state := v ^ seed
+		uniform := func() float64 {
+			state = splitMix64(state)
+			// 53 random bits in (0, 1).
+			return (float64(state>>11) + 0.5) / (1 << 53)
+		}
+		r := -math.Log(uniform() * uniform())
+		c := -math.Log(uniform() * uniform())
+		beta := uniform()
+		t := math.Floor(lnWeight/r + beta)
+		lnY := r * (t - beta)
+		lnA := math.Log(c) - lnY - r
+		if lnA < m.mins[i] {
+			m.mins[i] = lnA
+			m.sig[i] = splitMix64(v ^ splitMix64(uint64(int64(t))^seed))
+		}
+	}
+}
+
+// Signature exports the weighted MinHash signature.
+func (m *WeightedMinhash) Signature() []uint64 {
+	sig := make([]uint64, len(m.sig))
+	copy(sig, m.sig)
+	return sig
+}
+
+// TotalWeight returns the sum of the weights of the pushed values.
+func (m *WeightedMinhash) TotalWeight() float64 {
+	return m.totalWeight
+}
+
+// WeightedContainment returns the estimated weighted containment of
+// sum(min(wQ, wX)) / sum(wQ).
+// q and x are the weighted MinHash signatures of Q and X respectively,
+// and qWeight and xWeight are their total weights.
+// If either total weight is 0, the result is defined to be 0.
+func WeightedContainment(q, x []uint64, qWeight, xWeight float64) float64 {
+	if qWeight == 0 || xWeight == 0 {
+		return 0.0
+	}
+	var eq int
+	for i, hv := range q {
+		if x[i] == hv {
+			eq++
+		}
+	}
+	jaccard := float64(eq) / float64(len(q))
+	c := (xWeight/qWeight + 1.0) * jaccard / (1.0 + jaccard)
+	if c > 1.0 {
+		return 1.0
+	}
+	return c
+}
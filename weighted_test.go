@@ -0,0 +1,62 @@
+package lshensemble
+
+import (
+	"math"
+	"testing"
+)
+
+func Test_WeightedMinhash(t *testing.T) {
+	d := data(1000)
+	m1 := NewWeightedMinhash(1, 256)
+	m2 := NewWeightedMinhash(1, 256)
+	// Q has every value with weight 1, X has the first half of the
+	// values with weight 10 and the other half with weight 1.
+	var qWeight, xWeight, intersection float64
+	for i, v := range d {
+		wx := 1.0
+		if i < 500 {
+			wx = 10.0
+		}
+		m1.Push(v, 1.0)
+		m2.Push(v, wx)
+		qWeight++
+		xWeight += wx
+		intersection += math.Min(1.0, wx)
+	}
+	if m2.TotalWeight() != xWeight {
+		t.Fatalf("Total weight %f, expecting %f", m2.TotalWeight(), xWeight)
+	}
+	sig1, sig2 := m1.Signature(), m2.Signature()
+	var eq int
+	for i := range sig1 {
+		if sig1[i] == sig2[i] {
+			eq++
+		}
+	}
+	est := float64(eq) / float64(len(sig1))
+	act := intersection / (qWeight + xWeight - intersection)
+	if math.Abs(est-act) > 0.1 {
+		t.Fatalf("Estimated weighted Jaccard %f, actual %f", est, act)
+	}
+	c := WeightedContainment(sig1, sig2, qWeight, xWeight)
+	if math.Abs(c-intersection/qWeight) > 0.2 {
+		t.Fatalf("Estimated weighted containment %f, actual %f", c, intersection/qWeight)
+	}
+}
+
+func Test_WeightedMinhashIdentical(t *testing.T) {
+	d := data(100)
+	m1 := NewWeightedMinhash(1, 64)
+	m2 := NewWeightedMinhash(1, 64)
+	for i, v := range d {
+		m1.Push(v, float64(i+1))
+		m2.Push(v, float64(i+1))
+	}
+	rec := NewWeightedDomainRecord("a", m1)
+	if rec.Size != 5050 {
+		t.Fatalf("Size %d, expecting 5050", rec.Size)
+	}
+	if c := WeightedContainment(rec.Signature, m2.Signature(), m1.TotalWeight(), m2.TotalWeight()); c != 1.0 {
+		t.Fatalf("Containment of identical domains is %f", c)
+	}
+}